@@ -0,0 +1,185 @@
+package cost
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// Estimate is the estimated cardinality and cost of one expr of a plan.
+type Estimate struct {
+	Card schema.Cardinality
+	// Cost is the cost of the operator alone, and Total is the cost of the
+	// operator and all of its inputs.
+	Cost  float64
+	Total float64
+}
+
+// Annotation holds the estimates for every expr of a plan.
+type Annotation struct {
+	j         join.Join
+	s         *schema.Schema
+	estimates map[join.ExprID]Estimate
+}
+
+// Annotate estimates the cardinality and cost of every expr of j according to
+// m. j may be any plan built in a Forest, not just one chosen by an orderer.
+func Annotate(j join.Join, m Model) *Annotation {
+	return AnnotateWith(j, j.Forest().Schema(), m)
+}
+
+// AnnotateWith is like Annotate, but estimates cardinalities using s rather
+// than the schema j was built for. s must have the same relations and
+// predicates, and may only differ in their statistics.
+func AnnotateWith(j join.Join, s *schema.Schema, m Model) *Annotation {
+	a := &Annotation{
+		j:         j,
+		s:         s,
+		estimates: make(map[join.ExprID]Estimate),
+	}
+	a.annotate(j.Root(), m)
+	return a
+}
+
+func (a *Annotation) props(g join.ExprID) Props {
+	f := a.j.Forest()
+	return Props{
+		Relations: f.GetMembers(g),
+		Card:      a.estimates[g].Card,
+		Physical:  f.Physical(g),
+	}
+}
+
+func (a *Annotation) annotate(g join.ExprID, m Model) Estimate {
+	if e, ok := a.estimates[g]; ok {
+		return e
+	}
+
+	f := a.j.Forest()
+	s := a.s
+	l, r := f.Children(g)
+
+	var e Estimate
+	switch op := f.Operator(g); op {
+	case join.Scan:
+		e.Card = s.Cardinality(f.Relation(g))
+		a.estimates[g] = e
+		e.Cost = m.Scan(a.props(g))
+
+	case join.Sort, join.Exchange, join.Distinct, join.GroupBy:
+		in := a.annotate(l, m)
+		e.Card = in.Card
+		e.Total = in.Total
+		switch op {
+		case join.Sort:
+			e.Cost = m.Sort(a.props(l))
+		case join.Exchange:
+			e.Cost = m.Exchange(a.props(l), f.Physical(g).Distribution)
+		case join.Distinct:
+			e.Card = s.DistinctCardinality(f.GetMembers(g), in.Card)
+			e.Cost = m.Distinct(a.props(l))
+		default:
+			e.Card = s.GroupCardinality(f.GetMembers(g), in.Card)
+			e.Cost = m.GroupBy(a.props(l))
+		}
+
+	default:
+		le, re := a.annotate(l, m), a.annotate(r, m)
+		e.Card = s.JoinCardinality(f.GetMembers(l), f.GetMembers(r), le.Card, re.Card)
+		e.Total = le.Total + re.Total
+		a.estimates[g] = e
+		e.Cost = m.Join(op, a.props(l), a.props(r), a.props(g))
+	}
+
+	e.Total += e.Cost
+	a.estimates[g] = e
+	return e
+}
+
+// Estimate returns the estimates for g, which must be part of the annotated
+// plan.
+func (a *Annotation) Estimate(g join.ExprID) Estimate {
+	e, ok := a.estimates[g]
+	if !ok {
+		panic(fmt.Sprintf("E%d is not part of the plan", g))
+	}
+	return e
+}
+
+// Root returns the estimates for the whole plan.
+func (a *Annotation) Root() Estimate {
+	return a.Estimate(a.j.Root())
+}
+
+// String formats the plan as a tree, one expr per line, with the estimated
+// number of rows each produces and its total cost.
+func (a *Annotation) String() string {
+	var buf bytes.Buffer
+	a.format(a.j.Root(), &buf, 0)
+	return buf.String()
+}
+
+func (a *Annotation) format(g join.ExprID, buf *bytes.Buffer, depth int) {
+	f := a.j.Forest()
+	for i := 0; i < depth; i++ {
+		buf.WriteString("  ")
+	}
+
+	op := f.Operator(g)
+	switch kind := f.JoinKind(g); {
+	case f.IsCrossProduct(g):
+		buf.WriteString("cross product")
+	case kind != schema.InnerJoin:
+		// "left outer join" becomes "left outer hash join".
+		fmt.Fprintf(buf, "%s %s", strings.TrimSuffix(kind.String(), " join"), op)
+	default:
+		buf.WriteString(op.String())
+	}
+	switch op {
+	case join.Scan:
+		r := f.Relation(g)
+		fmt.Fprintf(buf, " %s", f.Schema().Relation(r).Name)
+		for i, filter := range f.Schema().Filters(r) {
+			if i == 0 {
+				buf.WriteString(" where ")
+			} else {
+				buf.WriteString(" and ")
+			}
+			buf.WriteString(filter.Description)
+		}
+	case join.Sort, join.MergeJoin:
+		fmt.Fprintf(buf, " on %d", int(f.Physical(g).Ordering))
+	case join.Exchange:
+		fmt.Fprintf(buf, " to %s", f.Physical(g).Distribution)
+	}
+
+	e := a.estimates[g]
+	fmt.Fprintf(buf, " (rows=%s, cost=%s)\n", formatFloat(float64(e.Card)), formatFloat(e.Total))
+
+	l, r := f.Children(g)
+	for _, c := range []join.ExprID{l, r} {
+		if c != 0 {
+			a.format(c, buf, depth+1)
+		}
+	}
+}
+
+// DOT returns the plan in the Graphviz DOT language, with each expr labelled
+// with the estimated number of rows it produces and its total cost.
+func (a *Annotation) DOT() string {
+	return a.j.DOT(func(g join.ExprID) string {
+		e := a.estimates[g]
+		return fmt.Sprintf("rows=%s\ncost=%s", formatFloat(float64(e.Card)), formatFloat(e.Total))
+	})
+}
+
+func formatFloat(f float64) string {
+	if f == math.Trunc(f) {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return fmt.Sprintf("%.2f", f)
+}
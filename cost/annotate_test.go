@@ -0,0 +1,148 @@
+package cost
+
+import (
+	"testing"
+
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+func TestAnnotate(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 10)
+	c := builder.AddRelation("C", 1000)
+
+	k := builder.AddPredicate(a, b, 0.01)
+	builder.AddPredicate(b, c, 0.5)
+
+	s := builder.MustBuild()
+
+	//         ⋈
+	//       /   \
+	//      ⋈ₘ    C
+	//    /   \
+	// sort   sort
+	//   |     |
+	//   A     B
+
+	f := join.NewForest(s)
+	ab := f.AddMergeJoin(
+		f.AddSort(f.AddLeaf(a), k),
+		f.AddSort(f.AddLeaf(b), k),
+		k,
+	)
+	root := f.AddJoin(ab, f.AddLeaf(c))
+
+	ann := Annotate(f.AsJoin(root), Local{})
+
+	if e := ann.Estimate(ab); e.Card != 10 || e.Cost != 10 {
+		t.Fatalf("expected merge join to produce 10 rows at a cost of 10, got %v", e)
+	}
+
+	expected := `hash join (rows=5000, cost=5707.60)
+  merge join on 1 (rows=10, cost=707.60)
+    sort on 1 (rows=100, cost=664.39)
+      scan A (rows=100, cost=0)
+    sort on 1 (rows=10, cost=33.22)
+      scan B (rows=10, cost=0)
+  scan C (rows=1000, cost=0)
+`
+	if actual := ann.String(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	if expected := ann.Estimate(ab).Total + 5000; ann.Root().Total != expected {
+		t.Fatalf("expected total cost of %v, got %v", expected, ann.Root().Total)
+	}
+}
+
+func TestAnnotateFilters(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 10)
+
+	builder.AddPredicate(a, b, 0.1)
+	builder.AddFilter(a, "a.status = 'x'", 0.1)
+	builder.AddFilter(a, "a.n > 5", 0.5)
+
+	f := join.NewForest(builder.MustBuild())
+	root := f.AddJoin(f.AddLeaf(a), f.AddLeaf(b))
+
+	expected := `hash join (rows=50, cost=50)
+  scan A where a.status = 'x' and a.n > 5 (rows=50, cost=0)
+  scan B (rows=10, cost=0)
+`
+	if actual := Annotate(f.AsJoin(root), Local{}).String(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+	if expected := "(σ(A) ⋈ B)"; f.AsJoin(root).String() != expected {
+		t.Fatalf("expected %q, got %q", expected, f.AsJoin(root))
+	}
+}
+
+func TestAnnotateCrossProduct(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 10)
+
+	f := join.NewForest(builder.MustBuild())
+	root := f.AddJoin(f.AddLeaf(a), f.AddLeaf(b))
+
+	expected := `cross product (rows=1000, cost=1000)
+  scan A (rows=100, cost=0)
+  scan B (rows=10, cost=0)
+`
+	if actual := Annotate(f.AsJoin(root), Local{}).String(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestAnnotateDOT(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 10)
+	builder.AddPredicate(a, b, 0.1)
+
+	f := join.NewForest(builder.MustBuild())
+	root := f.AddJoin(f.AddLeaf(a), f.AddLeaf(b))
+
+	expected := `digraph G {
+  e3 [label="⋈\nrows=100\ncost=100"];
+  e3 -> e1;
+  e3 -> e2;
+  e1 [label="A\nrows=100\ncost=0"];
+  e2 [label="B\nrows=10\ncost=0"];
+}
+`
+	if actual := Annotate(f.AsJoin(root), Local{}).DOT(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestAnnotateOuterJoin(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 10)
+	builder.AddPredicate(a, b, 0.001)
+	builder.AddOuterJoin(schema.LeftOuterJoin, schema.S(a), schema.S(b))
+
+	f := join.NewForest(builder.MustBuild())
+	root := f.AddJoin(f.AddLeaf(b), f.AddLeaf(a))
+
+	expected := `right outer hash join (rows=100, cost=100)
+  scan B (rows=10, cost=0)
+  scan A (rows=100, cost=0)
+`
+	if actual := Annotate(f.AsJoin(root), Local{}).String(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+	if expected := "(B ⟖ A)"; f.AsJoin(root).String() != expected {
+		t.Fatalf("expected %q, got %q", expected, f.AsJoin(root))
+	}
+}
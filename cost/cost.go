@@ -0,0 +1,67 @@
+package cost
+
+import (
+	"math"
+
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// Props describes a stream of rows produced or consumed by an operator.
+type Props struct {
+	Relations schema.RelSet
+	Card      schema.Cardinality
+	Physical  join.Physical
+}
+
+// Model estimates the cost of the physical operators of a plan. The cost of a
+// plan is the sum of the costs of its operators, so each method only accounts
+// for the work done by the operator itself and not for producing its inputs.
+type Model interface {
+	// Scan returns the cost of reading out, the rows of a base relation.
+	Scan(out Props) float64
+	// Join returns the cost of joining l and r with op to produce out.
+	Join(op join.Operator, l, r, out Props) float64
+	// Sort returns the cost of sorting the rows of in.
+	Sort(in Props) float64
+	// Exchange returns the cost of moving the rows of in between nodes so that
+	// they are distributed as d.
+	Exchange(in Props, d schema.Distribution) float64
+	// Distinct returns the cost of removing duplicates from the rows of in.
+	Distinct(in Props) float64
+	// GroupBy returns the cost of aggregating the rows of in.
+	GroupBy(in Props) float64
+}
+
+// Local is a Model for executing a plan on a single node. The cost of a join
+// is the number of rows it produces, the cost of sorting n rows is n*log(n),
+// and the cost of removing duplicates from or aggregating them is n. Scans
+// and exchanges are free.
+type Local struct{}
+
+var _ Model = Local{}
+
+func (Local) Scan(out Props) float64 {
+	return 0
+}
+
+func (Local) Join(op join.Operator, l, r, out Props) float64 {
+	return float64(out.Card)
+}
+
+func (Local) Sort(in Props) float64 {
+	n := float64(in.Card)
+	return n * math.Log2(math.Max(n, 1))
+}
+
+func (Local) Exchange(in Props, d schema.Distribution) float64 {
+	return 0
+}
+
+func (Local) Distinct(in Props) float64 {
+	return float64(in.Card)
+}
+
+func (Local) GroupBy(in Props) float64 {
+	return float64(in.Card)
+}
@@ -0,0 +1,40 @@
+package cost
+
+import (
+	"testing"
+
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+func TestDistributedExchange(t *testing.T) {
+	m := Distributed{Nodes: 4, Network: 2}
+
+	hashed := schema.HashedOn(1)
+	single := schema.Distribution{Kind: schema.Singleton}
+	replicated := schema.Distribution{Kind: schema.Replicated}
+
+	cases := []struct {
+		from, to schema.Distribution
+		exp      float64
+	}{
+		{single, hashed, 150},
+		{hashed, single, 150},
+		{hashed, schema.HashedOn(2), 150},
+		{hashed, replicated, 600},
+		{single, replicated, 600},
+		{replicated, hashed, 0},
+		{replicated, single, 0},
+	}
+
+	for _, tc := range cases {
+		in := Props{Card: 100, Physical: join.Physical{Distribution: tc.from}}
+		if actual := m.Exchange(in, tc.to); actual != tc.exp {
+			t.Errorf("expected exchange from %s to %s to cost %v, not %v", tc.from, tc.to, tc.exp, actual)
+		}
+	}
+
+	if actual := (Distributed{Nodes: 1, Network: 2}).Exchange(Props{Card: 100}, replicated); actual != 0 {
+		t.Errorf("expected exchange on a single node to be free, not %v", actual)
+	}
+}
@@ -0,0 +1,56 @@
+package cost
+
+import (
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// Distributed is a Model for executing a plan across a cluster. Operators cost
+// the same as they do in Local, and exchanges are charged for each row they
+// send over the network.
+type Distributed struct {
+	// Nodes is the number of nodes in the cluster.
+	Nodes int
+	// Network is the cost of sending a row to another node, relative to the
+	// cost of producing a row in a join.
+	Network float64
+}
+
+var _ Model = Distributed{}
+
+func (m Distributed) Scan(out Props) float64 {
+	return Local{}.Scan(out)
+}
+
+func (m Distributed) Join(op join.Operator, l, r, out Props) float64 {
+	return Local{}.Join(op, l, r, out)
+}
+
+func (m Distributed) Sort(in Props) float64 {
+	return Local{}.Sort(in)
+}
+
+func (m Distributed) Distinct(in Props) float64 {
+	return Local{}.Distinct(in)
+}
+
+func (m Distributed) GroupBy(in Props) float64 {
+	return Local{}.GroupBy(in)
+}
+
+// Exchange charges for the rows which must be sent to another node. A
+// replicated input can be redistributed without sending anything, since
+// every node already has every row.
+func (m Distributed) Exchange(in Props, d schema.Distribution) float64 {
+	if in.Physical.Distribution.Kind == schema.Replicated || m.Nodes <= 1 {
+		return 0
+	}
+	n := float64(m.Nodes)
+	rows := float64(in.Card)
+	if d.Kind == schema.Replicated {
+		// Every row is sent to every other node.
+		return rows * (n - 1) * m.Network
+	}
+	// Each row stays where it is with probability 1/n.
+	return rows * (n - 1) / n * m.Network
+}
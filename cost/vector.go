@@ -0,0 +1,127 @@
+package cost
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// Objective is one of the dimensions a plan can be costed along.
+type Objective int
+
+const (
+	// Work is the total cost of every operator of a plan.
+	Work Objective = iota
+	// Memory is the largest number of rows a plan holds in memory at once.
+	Memory
+	// Time is the response time of a plan, if the inputs of every operator
+	// are computed in parallel.
+	Time
+	NumObjectives
+)
+
+func (o Objective) String() string {
+	switch o {
+	case Work:
+		return "work"
+	case Memory:
+		return "memory"
+	case Time:
+		return "time"
+	}
+	panic(fmt.Sprintf("unknown objective %d", int(o)))
+}
+
+// Vector is the cost of a plan along each Objective.
+type Vector [NumObjectives]float64
+
+// Dominates returns whether v is at least as good as w along every Objective.
+// Two equal Vectors dominate each other.
+func (v Vector) Dominates(w Vector) bool {
+	for i := range v {
+		if v[i] > w[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MultiModel estimates the cost of the physical operators of a plan along
+// each Objective. Unlike a Model, the cost of a plan is not necessarily the
+// sum of the costs of its operators, so each method is given the costs of the
+// inputs of the operator and returns the cost of the plan rooted at it.
+type MultiModel interface {
+	Scan(out Props) Vector
+	Join(op join.Operator, l, r, out Props, lc, rc Vector) Vector
+	Sort(in Props, c Vector) Vector
+	Exchange(in Props, d schema.Distribution, c Vector) Vector
+	Distinct(in Props, c Vector) Vector
+	GroupBy(in Props, c Vector) Vector
+}
+
+// Resources is a MultiModel which estimates the Work of a plan using Model,
+// and assumes that a hash join builds a hash table over its right input
+// before streaming its left input through it, and that a sort, a distinct or
+// an aggregation holds all of its input in memory.
+type Resources struct {
+	Model Model
+}
+
+var _ MultiModel = Resources{}
+
+func (m Resources) Scan(out Props) Vector {
+	w := m.Model.Scan(out)
+	return Vector{Work: w, Time: w}
+}
+
+func (m Resources) Join(op join.Operator, l, r, out Props, lc, rc Vector) Vector {
+	w := m.Model.Join(op, l, r, out)
+	mem := math.Max(lc[Memory], rc[Memory])
+	if op == join.HashJoin {
+		// The hash table is held in memory while the left input runs.
+		mem = math.Max(rc[Memory], float64(r.Card)+lc[Memory])
+	}
+	return Vector{
+		Work:   lc[Work] + rc[Work] + w,
+		Memory: mem,
+		Time:   math.Max(lc[Time], rc[Time]) + w,
+	}
+}
+
+func (m Resources) Sort(in Props, c Vector) Vector {
+	w := m.Model.Sort(in)
+	return Vector{
+		Work:   c[Work] + w,
+		Memory: math.Max(c[Memory], float64(in.Card)),
+		Time:   c[Time] + w,
+	}
+}
+
+func (m Resources) Distinct(in Props, c Vector) Vector {
+	w := m.Model.Distinct(in)
+	return Vector{
+		Work:   c[Work] + w,
+		Memory: math.Max(c[Memory], float64(in.Card)),
+		Time:   c[Time] + w,
+	}
+}
+
+func (m Resources) GroupBy(in Props, c Vector) Vector {
+	w := m.Model.GroupBy(in)
+	return Vector{
+		Work:   c[Work] + w,
+		Memory: math.Max(c[Memory], float64(in.Card)),
+		Time:   c[Time] + w,
+	}
+}
+
+func (m Resources) Exchange(in Props, d schema.Distribution, c Vector) Vector {
+	w := m.Model.Exchange(in, d)
+	return Vector{
+		Work:   c[Work] + w,
+		Memory: c[Memory],
+		Time:   c[Time] + w,
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"math"
+	"sort"
+
+	"github.com/justinj/joinorder/cost"
 	"github.com/justinj/joinorder/join"
 	"github.com/justinj/joinorder/schema"
 	"github.com/justinj/joinorder/util"
@@ -9,76 +13,476 @@ import (
 type DPSizeOrderer struct {
 	s     *schema.Schema
 	j     *join.Forest
-	costs map[join.GroupID]float64
-	cards map[join.GroupID]schema.Cardinality
+	m     cost.Model
+	costs map[join.ExprID]float64
+	cards map[join.ExprID]schema.Cardinality
+
+	// sets[k] holds every set of k relations that has a plan. The group for
+	// each set holds the cheapest plan found for it for each of the physical
+	// properties it can be produced with.
+	sets [][]schema.RelSet
 }
 
 func NewDPSizeOrderer(s *schema.Schema) *DPSizeOrderer {
 	return &DPSizeOrderer{
 		s:     s,
 		j:     join.NewForest(s),
-		costs: make(map[join.GroupID]float64),
-		cards: make(map[join.GroupID]schema.Cardinality),
+		m:     cost.Local{},
+		costs: make(map[join.ExprID]float64),
+		cards: make(map[join.ExprID]schema.Cardinality),
+		sets:  make([][]schema.RelSet, s.NumRels()+1),
 	}
 }
 
-func (o *DPSizeOrderer) Order() join.Join {
-	subproblems := [][]join.GroupID{nil, []join.GroupID{0}}
+// SetCostModel sets the Model used to cost plans. The default is cost.Local.
+func (o *DPSizeOrderer) SetCostModel(m cost.Model) {
+	o.m = m
+}
 
-	units := schema.NewRelSetMap()
-	for i := 1; i <= o.s.NumRels(); i++ {
-		units.Set(util.MakeFastIntSet(i), i)
-		l := o.j.AddLeaf(schema.RelationID(i))
-		subproblems[1] = append(subproblems[1], l)
-		o.costs[l] = 0
-		o.cards[l] = o.s.Cardinality(schema.RelationID(i))
+// props returns the properties of the rows of set with the physical
+// properties p, which aren't grouped.
+func (o *DPSizeOrderer) props(set schema.RelSet, p join.Physical) cost.Props {
+	return cost.Props{
+		Relations: set,
+		Card:      o.card(set),
+		Physical:  p,
+	}
+}
+
+// card returns the cardinality of the plans for set which aren't grouped or
+// deduplicated. Grouped plans have fewer rows, depending on where their rows
+// were aggregated.
+func (o *DPSizeOrderer) card(set schema.RelSet) schema.Cardinality {
+	return o.cards[o.plans(set, join.Logical{})[0]]
+}
+
+// plans returns the plans for set with the Logical properties l.
+func (o *DPSizeOrderer) plans(set schema.RelSet, l join.Logical) []join.ExprID {
+	g, ok := o.j.Group(set, l)
+	if !ok {
+		return nil
+	}
+	return o.j.Exprs(g)
+}
+
+// candidates returns the plans which can produce the rows of set with the
+// Logical properties l: the ones which already have them, and if l is
+// grouped, the ones which can be aggregated.
+func (o *DPSizeOrderer) candidates(set schema.RelSet, l join.Logical) []join.ExprID {
+	if !l.Grouped {
+		return o.plans(set, l)
+	}
+	var result []join.ExprID
+	result = append(result, o.plans(set, join.Logical{})...)
+	return append(result, o.plans(set, l)...)
+}
+
+func (o *DPSizeOrderer) planProps(g join.ExprID) cost.Props {
+	return cost.Props{
+		Relations: o.j.GetMembers(g),
+		Card:      o.cards[g],
+		Physical:  o.j.Physical(g),
+	}
+}
+
+// enforcement is a way of producing the rows of a set of relations with some
+// physical properties: an existing plan, which might need to be aggregated,
+// exchanged and then sorted.
+type enforcement struct {
+	g        join.ExprID
+	group    bool
+	exchange bool
+	sort     bool
+	cost     float64
+	card     schema.Cardinality
+}
+
+// enforce returns the cheapest way to produce the rows of set with the
+// Logical properties l and the physical properties p. A zero Ordering in p is
+// satisfied by rows in any order, and a grouped l by aggregating rows which
+// aren't grouped. The cost is +Inf if there is no plan for set.
+func (o *DPSizeOrderer) enforce(set schema.RelSet, l join.Logical, p join.Physical) enforcement {
+	best := enforcement{cost: math.Inf(1)}
+	for _, g := range o.candidates(set, l) {
+		in := o.planProps(g)
+		e := enforcement{g: g, cost: o.costs[g], card: in.Card}
+		if l.Grouped && !o.j.Logical(g).Grouped {
+			e.group = true
+			e.cost += o.m.GroupBy(in)
+			e.card = o.s.GroupCardinality(set, in.Card)
+			in.Card = e.card
+			in.Physical = join.Physical{Distribution: in.Physical.Distribution}
+		}
+		if in.Physical.Distribution != p.Distribution {
+			e.exchange = true
+			e.cost += o.m.Exchange(in, p.Distribution)
+			in.Physical = join.Physical{Distribution: p.Distribution}
+		}
+		if p.Ordering != 0 && in.Physical.Ordering != p.Ordering {
+			e.sort = true
+			e.cost += o.m.Sort(in)
+		}
+		if e.cost < best.cost {
+			best = e
+		}
+	}
+	return best
+}
+
+// materialize adds the aggregation, exchange and sort required by e to the
+// forest, and returns the resulting plan. Each of them is recorded as a plan
+// for set in its own right.
+func (o *DPSizeOrderer) materialize(set schema.RelSet, p join.Physical, e enforcement) join.ExprID {
+	g := e.g
+	if e.group {
+		next := o.j.AddGroupBy(g)
+		o.record(next, o.s.GroupCardinality(set, o.cards[g]), o.costs[g]+o.m.GroupBy(o.planProps(g)))
+		g = next
 	}
+	if e.exchange {
+		next := o.j.AddExchange(g, p.Distribution)
+		o.record(next, o.cards[g], o.costs[g]+o.m.Exchange(o.planProps(g), p.Distribution))
+		g = next
+	}
+	if e.sort {
+		next := o.j.AddSort(g, p.Ordering)
+		o.record(next, o.cards[g], o.costs[g]+o.m.Sort(o.planProps(g)))
+		g = next
+	}
+	return g
+}
+
+// record records the cardinality and cost of g, a plan for its set of
+// relations. Only the cheapest plan for each physical properties is kept in
+// g's group, so whichever of g and any other plan with the same ones is more
+// expensive is removed from it.
+func (o *DPSizeOrderer) record(g join.ExprID, card schema.Cardinality, cost float64) {
+	o.cards[g] = card
+	o.costs[g] = cost
+
+	set := o.j.GetMembers(g)
+	l := o.j.Logical(g)
+	plans := o.j.Exprs(o.j.GroupOf(g))
+	if l == (join.Logical{}) && len(plans) == 1 {
+		o.sets[set.Len()] = append(o.sets[set.Len()], set)
+	}
+
+	p := o.j.Physical(g)
+	for _, old := range plans {
+		if old == g || o.j.Physical(old) != p {
+			continue
+		}
+		if o.finished(set, l, cost, card) < o.finished(set, l, o.costs[old], o.cards[old]) {
+			o.j.Remove(old)
+		} else {
+			o.j.Remove(g)
+		}
+		return
+	}
+}
 
-	bests := []*schema.RelSetMap{nil, units}
-	var finalIdx join.GroupID
+func (o *DPSizeOrderer) Order() join.Join {
+	for i := 1; i <= o.s.NumRels(); i++ {
+		r := schema.RelationID(i)
+		l := o.j.AddLeaf(r)
+		card := o.s.Cardinality(r)
+		o.record(l, card, o.m.Scan(cost.Props{
+			Relations: o.j.GetMembers(l),
+			Card:      card,
+			Physical:  o.j.Physical(l),
+		}))
+	}
 
 	for s := 2; s <= o.s.NumRels(); s++ {
-		bests = append(bests, schema.NewRelSetMap())
-		subproblems = append(subproblems, []join.GroupID{0})
 		for s1 := 1; s1 < s; s1++ {
 			s2 := s - s1
-			for _, l := range subproblems[s1][1:] {
-				for _, r := range subproblems[s2][1:] {
-					lMembers := o.j.GetMembers(l)
-					rMembers := o.j.GetMembers(r)
-
+			for _, lMembers := range o.sets[s1] {
+				for _, rMembers := range o.sets[s2] {
 					if lMembers.Intersects(rMembers) {
 						continue
 					}
 
-					if !o.s.SubgraphsAdjacent(lMembers, rMembers) {
+					if !o.s.SubgraphsAdjacent(lMembers, rMembers) || !o.s.CanJoin(lMembers, rMembers) {
 						continue
 					}
 
-					resultingSet := lMembers.Union(rMembers)
-
-					lCost := o.costs[l]
-					rCost := o.costs[r]
-					sel := o.s.ComplexSelectivity(lMembers, rMembers)
-
-					newCard := float64(o.cards[l]) * float64(o.cards[r]) * float64(sel)
-					newCost := lCost + rCost + newCard
-
-					oldBestIdx := join.GroupID(bests[s].Get(resultingSet))
-					if oldBestIdx == 0 || newCost < o.costs[oldBestIdx] {
-						new := o.j.AddJoin(l, r)
-						if oldBestIdx == 0 {
-							subproblems[s] = append(subproblems[s], new)
-						}
-						finalIdx = new
-						o.cards[new] = schema.Cardinality(newCard)
-						o.costs[new] = newCost
-						bests[s].Set(resultingSet, int(new))
+					o.join(lMembers, rMembers)
+				}
+			}
+		}
+	}
+	o.crossComponents()
+
+	all := util.MakeFastIntSet()
+	all.AddRange(1, o.s.NumRels())
+
+	// The result is returned from a single node.
+	p := join.Physical{Ordering: o.s.OrderBy()}
+	if len(o.s.GroupBy()) == 0 {
+		return o.j.AsJoin(o.materialize(all, p, o.enforce(all, join.Logical{}, p)))
+	}
+
+	// The final aggregation combines any partial aggregates below it, and its
+	// output is sorted afterwards.
+	var best enforcement
+	bestCost := math.Inf(1)
+	for _, grouped := range []bool{false, true} {
+		e := o.enforce(all, join.Logical{Grouped: grouped}, join.Physical{})
+		c := e.cost + o.m.GroupBy(cost.Props{Relations: all, Card: e.card})
+		if c < bestCost {
+			best, bestCost = e, c
+		}
+	}
+	g := o.j.AddGroupBy(o.materialize(all, join.Physical{}, best))
+	if p.Ordering != 0 {
+		g = o.j.AddSort(g, p.Ordering)
+	}
+	return o.j.AsJoin(g)
+}
+
+// crossComponents combines the plans for each connected component of the
+// query graph, which the search never joins to each other, with cross
+// products. Components are added in increasing order of cardinality, each
+// joined to the result of the ones before it.
+func (o *DPSizeOrderer) crossComponents() {
+	components := o.s.Components()
+	if len(components) < 2 {
+		return
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		return o.props(components[i], join.Physical{}).Card < o.props(components[j], join.Physical{}).Card
+	})
+
+	acc := components[0]
+	for _, c := range components[1:] {
+		o.join(acc, c)
+		o.join(c, acc)
+		acc = acc.Union(c)
+	}
+}
+
+// join considers each way of joining l and r.
+func (o *DPSizeOrderer) join(lMembers, rMembers schema.RelSet) {
+	keys := o.s.JoinKeys(lMembers, rMembers)
+	dists := o.distributions(lMembers, rMembers, keys)
+	for _, g := range o.groupings(lMembers, rMembers) {
+		for _, dl := range dists {
+			for _, dr := range dists {
+				d, ok := o.s.JoinDistribution(lMembers, rMembers, dl, dr)
+				if !ok {
+					continue
+				}
+				o.consider(
+					join.HashJoin, lMembers, rMembers, g,
+					join.Physical{Distribution: dl},
+					join.Physical{Distribution: dr},
+					join.Physical{Distribution: d},
+				)
+				for _, k := range keys {
+					o.consider(
+						join.MergeJoin, lMembers, rMembers, g,
+						join.Physical{Ordering: k, Distribution: dl},
+						join.Physical{Ordering: k, Distribution: dr},
+						join.Physical{Ordering: k, Distribution: d},
+					)
+				}
+			}
+		}
+	}
+	if o.s.JoinKind(lMembers, rMembers) == schema.SemiJoin {
+		newCard := o.s.JoinCardinality(lMembers, rMembers, o.card(lMembers), o.card(rMembers))
+		o.considerDistinct(lMembers, rMembers, newCard, keys, dists)
+	}
+}
+
+type grouping struct {
+	l, r bool
+}
+
+// groupings returns which of the inputs of a join of l and r are worth
+// considering with their rows grouped. If the query has a GROUP BY, the
+// inputs of an inner join can be aggregated eagerly, as described by Yan and
+// Larson, "Eager Aggregation and Lazy Aggregation". Only one grouped plan is
+// kept for each set of relations and physical properties, rather than every
+// plan which isn't dominated in both cost and cardinality as in Eich, Fender
+// and Moerkotte, "Efficient Generation of Query Plans Containing Group-By,
+// Join, and Groupjoin", so the cheapest plan overall may be missed.
+func (o *DPSizeOrderer) groupings(l, r schema.RelSet) []grouping {
+	if len(o.s.GroupBy()) == 0 || o.s.JoinKind(l, r) != schema.InnerJoin {
+		return []grouping{{}}
+	}
+	return []grouping{{}, {l: true}, {r: true}, {l: true, r: true}}
+}
+
+// finished returns the cost of a plan for set with the Logical properties l
+// and cardinality card, including the final aggregation of its rows if they
+// are grouped. Grouped plans can differ in cardinality as well as cost, so
+// this keeps a plan which is cheap because it aggregated little from being
+// preferred to one which will be cheaper to finish.
+func (o *DPSizeOrderer) finished(set schema.RelSet, l join.Logical, c float64, card schema.Cardinality) float64 {
+	if !l.Grouped {
+		return c
+	}
+	return c + o.m.GroupBy(cost.Props{Relations: set, Card: card})
+}
+
+// distinctOn returns whether duplicates can be removed from rows distributed
+// as d by each node on its own, before joining them on one of keys.
+func distinctOn(d schema.Distribution, keys []schema.JoinKey) bool {
+	if d.Kind != schema.Hashed {
+		return d.Kind != schema.Random
+	}
+	for _, k := range keys {
+		if d.Key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// considerDistinct considers performing the semi join of l and r as an inner
+// hash join of l and the rows of r with their duplicates removed, which is
+// cheaper if moving them between nodes is expensive or the join is cheaper
+// the other way around. The duplicates are removed before the rows are moved.
+func (o *DPSizeOrderer) considerDistinct(
+	lMembers, rMembers schema.RelSet,
+	card schema.Cardinality,
+	keys []schema.JoinKey,
+	dists []schema.Distribution,
+) {
+	resultingSet := lMembers.Union(rMembers)
+	for _, g := range o.plans(rMembers, join.Logical{}) {
+		in := o.planProps(g)
+		if !distinctOn(in.Physical.Distribution, keys) {
+			continue
+		}
+		distinct := in
+		distinct.Card = o.s.DistinctCardinality(rMembers, in.Card)
+		distinct.Physical.Ordering = 0
+		distinctCost := o.costs[g] + o.m.Distinct(in)
+
+		for _, dl := range dists {
+			for _, dr := range dists {
+				lp := join.Physical{Distribution: dl}
+				le := o.enforce(lMembers, join.Logical{}, lp)
+				rp := join.Physical{Distribution: dr}
+				rCost := distinctCost
+				exchange := distinct.Physical != rp
+				if exchange {
+					rCost += o.m.Exchange(distinct, dr)
+				}
+				r := distinct
+				r.Physical = rp
+
+				// An inner join's inputs can go either way around.
+				for _, flip := range []bool{false, true} {
+					var d schema.Distribution
+					var ok bool
+					if flip {
+						d, ok = o.s.JoinDistribution(rMembers, lMembers, dr, dl)
+					} else {
+						d, ok = o.s.JoinDistribution(lMembers, rMembers, dl, dr)
+					}
+					if !ok {
+						continue
+					}
+					out := join.Physical{Distribution: d}
+					lProps, rProps := o.props(lMembers, lp), r
+					if flip {
+						lProps, rProps = rProps, lProps
+					}
+					newCost := le.cost + rCost + o.m.Join(
+						join.HashJoin, lProps, rProps,
+						cost.Props{Relations: resultingSet, Card: card, Physical: out},
+					)
+					if newCost >= o.enforce(resultingSet, join.Logical{}, out).cost {
+						continue
 					}
+
+					lg := o.materialize(lMembers, lp, le)
+					rg := o.j.AddDistinct(g)
+					o.record(rg, distinct.Card, distinctCost)
+					if exchange {
+						next := o.j.AddExchange(rg, dr)
+						o.record(next, distinct.Card, rCost)
+						rg = next
+					}
+					if flip {
+						lg, rg = rg, lg
+					}
+					o.record(o.j.AddJoin(lg, rg), card, newCost)
 				}
 			}
 		}
 	}
+}
 
-	return o.j.AsJoin(finalIdx)
+// distributions returns the distributions worth considering for the inputs
+// of a join of l and r: the ones they already have, partitioned on one of the
+// keys they are joined on, or replicated.
+func (o *DPSizeOrderer) distributions(l, r schema.RelSet, keys []schema.JoinKey) []schema.Distribution {
+	var result []schema.Distribution
+	add := func(d schema.Distribution) {
+		for _, e := range result {
+			if d == e {
+				return
+			}
+		}
+		result = append(result, d)
+	}
+	for _, set := range []schema.RelSet{l, r} {
+		for _, g := range o.plans(set, join.Logical{}) {
+			add(o.j.Physical(g).Distribution)
+		}
+	}
+
+	// Rows on a single node never need to be moved.
+	if len(result) == 1 && result[0].Kind == schema.Singleton {
+		return result
+	}
+
+	for _, k := range keys {
+		add(schema.HashedOn(k))
+	}
+	add(schema.Distribution{Kind: schema.Replicated})
+	return result
+}
+
+// consider adds a join of l and r with op as a plan for their union if it is
+// cheaper than any existing way of producing it with the same properties. g
+// says which inputs are grouped, lp and rp are the physical properties each
+// input needs, and out those of the result.
+func (o *DPSizeOrderer) consider(
+	op join.Operator,
+	lMembers, rMembers schema.RelSet,
+	g grouping,
+	lp, rp, out join.Physical,
+) {
+	resultingSet := lMembers.Union(rMembers)
+	outLogical := join.Logical{Grouped: g.l || g.r}
+
+	le := o.enforce(lMembers, join.Logical{Grouped: g.l}, lp)
+	re := o.enforce(rMembers, join.Logical{Grouped: g.r}, rp)
+	card := o.s.JoinCardinality(lMembers, rMembers, le.card, re.card)
+	newCost := le.cost + re.cost + o.m.Join(
+		op,
+		cost.Props{Relations: lMembers, Card: le.card, Physical: lp},
+		cost.Props{Relations: rMembers, Card: re.card, Physical: rp},
+		cost.Props{Relations: resultingSet, Card: card, Physical: out},
+	)
+
+	old := o.enforce(resultingSet, outLogical, out)
+	if o.finished(resultingSet, outLogical, newCost, card) >= o.finished(resultingSet, outLogical, old.cost, old.card) {
+		return
+	}
+
+	l := o.materialize(lMembers, lp, le)
+	r := o.materialize(rMembers, rp, re)
+	var new join.ExprID
+	if op == join.MergeJoin {
+		new = o.j.AddMergeJoin(l, r, out.Ordering)
+	} else {
+		new = o.j.AddJoin(l, r)
+	}
+	o.record(new, card, newCost)
 }
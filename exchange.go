@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// joinInputs returns the distributions the inputs of a join of l and r, whose
+// rows are distributed as dl and dr, can be moved to so that the join can be
+// performed in place: their own, partitioned on one of the keys they are
+// joined on, replicated, or gathered on a single node. Those which move fewer
+// rows, counting a replicated input as moved twice, come first.
+func joinInputs(s *schema.Schema, l, r schema.RelSet, dl, dr schema.Distribution) [][2]schema.Distribution {
+	candidates := []schema.Distribution{dl, dr}
+	for _, k := range s.JoinKeys(l, r) {
+		candidates = append(candidates, schema.HashedOn(k))
+	}
+	candidates = append(candidates, schema.Distribution{Kind: schema.Replicated}, schema.Distribution{})
+
+	moves := func(from, to schema.Distribution) int {
+		switch {
+		case from == to:
+			return 0
+		case to.Kind == schema.Replicated:
+			return 2
+		}
+		return 1
+	}
+	// byMoves[n] holds the pairs which move the inputs n times.
+	var byMoves [5][][2]schema.Distribution
+	seen := make(map[[2]schema.Distribution]bool)
+	for _, a := range candidates {
+		for _, b := range candidates {
+			pair := [2]schema.Distribution{a, b}
+			if seen[pair] {
+				continue
+			}
+			seen[pair] = true
+			if _, ok := s.JoinDistribution(l, r, a, b); ok {
+				n := moves(dl, a) + moves(dr, b)
+				byMoves[n] = append(byMoves[n], pair)
+			}
+		}
+	}
+	var result [][2]schema.Distribution
+	for _, pairs := range byMoves {
+		result = append(result, pairs...)
+	}
+	return result
+}
+
+// addJoin adds a hash join of l and r to j, first moving the rows of either
+// between nodes, in the first of the ways given by joinInputs, if the join
+// can't be performed where they are.
+func addJoin(j *join.Forest, l, r join.ExprID) join.ExprID {
+	d := joinInputs(
+		j.Schema(), j.GetMembers(l), j.GetMembers(r), j.Physical(l).Distribution, j.Physical(r).Distribution,
+	)[0]
+	return j.AddJoin(exchange(j, l, d[0]), exchange(j, r, d[1]))
+}
+
+// finish adds what g, a plan joining every relation, needs to produce the
+// result of the query to j: gathering its rows on a single node, the final
+// aggregation if the query has a GROUP BY, and a sort if it has an ORDER BY.
+func finish(j *join.Forest, g join.ExprID) join.ExprID {
+	s := j.Schema()
+	g = exchange(j, g, schema.Distribution{})
+	if len(s.GroupBy()) > 0 {
+		g = j.AddGroupBy(g)
+	}
+	if k := s.OrderBy(); k != 0 && j.Physical(g).Ordering != k {
+		g = j.AddSort(g, k)
+	}
+	return g
+}
+
+// exchange returns g with its rows distributed as d, adding an exchange to j
+// if they aren't already.
+func exchange(j *join.Forest, g join.ExprID, d schema.Distribution) join.ExprID {
+	if j.Physical(g).Distribution == d {
+		return g
+	}
+	return j.AddExchange(g, d)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
 	"github.com/justinj/joinorder/join"
 	"github.com/justinj/joinorder/schema"
@@ -136,29 +137,117 @@ func (o *IKKBZOrderer) ChildrenOf(r schema.RelationID) []schema.RelationID {
 	return result
 }
 
-// Order implementes the Ibaraki/Kameda algorithm for finding the optimal
+// Order implements the Ibaraki/Kameda algorithm for finding the optimal
 // left-deep join order.
+//
+// Each connected component of the query graph is ordered separately, and the
+// components are then combined with cross products in increasing order of
+// cardinality. The sequences found for each root of a component rely on the
+// selectivities being independent, but the choice between them honours any
+// cardinalities set with SetCardinality. The right side of a semi or anti
+// join is joined as a whole, so the plan is only left-deep if each of them is
+// a single relation; if no sequence can be split up that way, the query is
+// ordered with a DPSizeOrderer instead.
+//
+// Rows are moved between nodes wherever a join needs them to be, and the plan
+// is finished off as described by finish.
+//
 // TODO: this should be extended to full IKKBZ.
 func (o *IKKBZOrderer) Order() join.Join {
 	j := join.NewForest(o.s)
+
+	type component struct {
+		g    join.ExprID
+		card schema.Cardinality
+	}
+	var components []component
+	for _, c := range o.s.Components() {
+		units, ok := o.solveComponent(c)
+		if !ok {
+			return NewDPSizeOrderer(o.s).Order()
+		}
+		var l join.ExprID
+		var seq Sequence
+		for _, u := range units {
+			r := j.AddLeaf(u[0])
+			for _, rel := range u[1:] {
+				r = addJoin(j, r, j.AddLeaf(rel))
+			}
+			if l == 0 {
+				l = r
+			} else {
+				l = addJoin(j, l, r)
+			}
+			seq = append(seq, u...)
+		}
+		components = append(components, component{g: l, card: NewOrderer(o.s).Cardinality(seq)})
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].card < components[j].card
+	})
+
+	l := components[0].g
+	for _, c := range components[1:] {
+		l = addJoin(j, l, c.g)
+	}
+	return j.AsJoin(finish(j, l))
+}
+
+// solveComponent returns the units of the cheapest sequence found by rooting
+// the connected component c at each of its relations, costed as if it were
+// joined left-deep. Sequences which would reorder outer, semi or anti joins
+// incorrectly are skipped, and it returns false if every one is.
+func (o *IKKBZOrderer) solveComponent(c schema.RelSet) ([]Sequence, bool) {
 	bestCost := float64(0)
-	var bestResult Sequence
-	for i := 1; i <= o.s.NumRels(); i++ {
+	var bestResult []Sequence
+	for i, ok := c.Next(0); ok; i, ok = c.Next(i + 1) {
 		flattened := o.SolveAtRoot(schema.RelationID(i))
-		cost := o.C(flattened)
-		if bestCost == 0 || cost < bestCost {
+		units, ok := o.units(flattened)
+		if !ok {
+			continue
+		}
+		cost := NewOrderer(o.s).Cost(flattened)
+		if bestResult == nil || cost < bestCost {
 			bestCost = cost
-			bestResult = flattened
+			bestResult = units
 		}
 	}
+	return bestResult, bestResult != nil
+}
 
-	l := j.AddLeaf(bestResult[0])
-	for i := 1; i < len(bestResult); i++ {
-		r := j.AddLeaf(bestResult[i])
-		l = j.AddJoin(l, r)
+// units splits s into the inputs which are joined in turn to the relations
+// before them: single relations, or runs of relations which must be joined to
+// each other first, like the right side of a semi join. It returns false if
+// s can't be split up this way.
+func (o *IKKBZOrderer) units(s Sequence) ([]Sequence, bool) {
+	result := []Sequence{{s[0]}}
+	prefix := schema.S(s[0])
+	var pending Sequence
+	pendingSet := schema.S()
+	for _, r := range s[1:] {
+		if len(pending) == 0 && o.joinable(prefix, schema.S(r)) {
+			result = append(result, Sequence{r})
+			prefix.Add(int(r))
+			continue
+		}
+		if len(pending) > 0 && !o.joinable(pendingSet, schema.S(r)) {
+			return nil, false
+		}
+		pending = append(pending, r)
+		pendingSet.Add(int(r))
+		if o.joinable(prefix, pendingSet) {
+			result = append(result, pending)
+			prefix = prefix.Union(pendingSet)
+			pending, pendingSet = nil, schema.S()
+		}
 	}
+	return result, len(pending) == 0
+}
 
-	return j.AsJoin(l)
+// joinable returns whether a and b can be joined to each other without a
+// cross product.
+func (o *IKKBZOrderer) joinable(a, b schema.RelSet) bool {
+	return o.s.SubgraphsAdjacent(a, b) && o.s.CanJoin(a, b)
 }
 
 func (o *IKKBZOrderer) SolveAtRoot(r schema.RelationID) Sequence {
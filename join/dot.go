@@ -0,0 +1,92 @@
+package join
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DOT returns the plan in the Graphviz DOT language, with an edge from each
+// expr to each of its inputs. If annotate is not nil, the lines it returns
+// for an expr are added to its label.
+func (j Join) DOT(annotate func(g ExprID) string) string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph G {\n")
+	seen := make(map[ExprID]bool)
+	var visit func(g ExprID)
+	visit = func(g ExprID) {
+		if seen[g] {
+			return
+		}
+		seen[g] = true
+		j.forest.writeDOTNode(g, &buf, annotate)
+		l, r := j.forest.Children(g)
+		for _, c := range []ExprID{l, r} {
+			if c != 0 {
+				visit(c)
+			}
+		}
+	}
+	visit(j.root)
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// DOT returns every expr of the forest in the Graphviz DOT language, with the
+// exprs of each group in a cluster. Exprs shared between plans appear once,
+// with an edge from each expr using them, and exprs which have been removed
+// from their group are dashed.
+func (j *Forest) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph G {\n")
+	members := make([][]ExprID, len(j.groups))
+	live := make([]bool, len(j.exprs))
+	for e := ExprID(1); int(e) < len(j.exprs); e++ {
+		g := j.exprs[e].group
+		members[g] = append(members[g], e)
+	}
+	for g := range j.groups {
+		for _, e := range j.groups[g].exprs {
+			live[e] = true
+		}
+	}
+	for g := GroupID(1); int(g) < len(j.groups); g++ {
+		fmt.Fprintf(&buf, "  subgraph cluster_g%d {\n", g)
+		fmt.Fprintf(&buf, "    label=%q;\n", j.groupString(g))
+		for _, e := range members[g] {
+			if live[e] {
+				fmt.Fprintf(&buf, "    e%d;\n", e)
+			} else {
+				fmt.Fprintf(&buf, "    e%d [style=dashed];\n", e)
+			}
+		}
+		buf.WriteString("  }\n")
+	}
+	for e := ExprID(1); int(e) < len(j.exprs); e++ {
+		j.writeDOTNode(e, &buf, nil)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// writeDOTNode writes the node for g and the edges to its inputs.
+func (j *Forest) writeDOTNode(g ExprID, buf *bytes.Buffer, annotate func(ExprID) string) {
+	e := j.exprs[g]
+	var label string
+	switch e.op {
+	case Scan:
+		label = j.leafString(e.relID)
+	case Sort, Exchange, Distinct, GroupBy:
+		label = unarySymbol(e)
+	default:
+		label = j.joinSymbol(e)
+	}
+	if annotate != nil {
+		label += "\n" + annotate(g)
+	}
+	fmt.Fprintf(buf, "  e%d [label=%q];\n", g, label)
+	for _, c := range []ExprID{e.l, e.r} {
+		if c != 0 {
+			fmt.Fprintf(buf, "  e%d -> e%d;\n", g, c)
+		}
+	}
+}
@@ -8,107 +8,458 @@ import (
 	"github.com/justinj/joinorder/util"
 )
 
+// ExprID identifies an expr: a single operator, whose inputs are particular
+// exprs, so that an expr is the root of one plan.
+type ExprID int
+
+// GroupID identifies a group: every expr added to the forest which produces
+// the same rows, those of the same set of relations with the same Logical
+// properties.
 type GroupID int
 
-// Forest is a memo-like structure describing a forest of possible join
-// trees.
+// Operator is the physical operator which produces the rows of an expr.
+type Operator int
+
+const (
+	Scan Operator = iota
+	HashJoin
+	MergeJoin
+	Sort
+	// Exchange moves rows between nodes to change their Distribution.
+	Exchange
+	// Distinct removes duplicate rows, so that a semi join can be performed
+	// as an inner join.
+	Distinct
+	// GroupBy aggregates rows, either partially below a join or as the final
+	// GROUP BY of the query.
+	GroupBy
+)
+
+func (op Operator) String() string {
+	switch op {
+	case Scan:
+		return "scan"
+	case HashJoin:
+		return "hash join"
+	case MergeJoin:
+		return "merge join"
+	case Sort:
+		return "sort"
+	case Exchange:
+		return "exchange"
+	case Distinct:
+		return "distinct"
+	case GroupBy:
+		return "group by"
+	}
+	panic(fmt.Sprintf("unknown operator %d", int(op)))
+}
+
+// Physical describes the physical properties of the rows produced by an expr,
+// which distinguish it from the other exprs of its group.
+type Physical struct {
+	// Ordering is the key the rows are sorted on, or 0 if they are in no
+	// particular order.
+	Ordering     schema.JoinKey
+	Distribution schema.Distribution
+}
+
+// Logical describes how the rows produced by an expr differ from those of
+// the join of its relations. Exprs with different Logical properties produce
+// different rows, so they are never in the same group.
+type Logical struct {
+	// Grouped is whether the rows have been aggregated by a GroupBy.
+	Grouped bool
+	// Deduplicated is whether the rows have had their duplicates removed by a
+	// Distinct, and haven't been joined since.
+	Deduplicated bool
+}
+
+// Forest is a memo describing a forest of possible join trees. Each expr
+// belongs to the group for the set of relations it joins and its Logical
+// properties, and the exprs of a group are alternative ways of producing its
+// rows. Exprs are never removed from the forest, but they can be removed from
+// their group once they are no longer worth considering.
 type Forest struct {
-	s     *schema.Schema
-	exprs []expr
+	s      *schema.Schema
+	exprs  []expr
+	groups []group
+	// groupIdx maps each set of relations to its group with each Logical
+	// properties.
+	groupIdx map[Logical]*schema.RelSetMap
+}
+
+type group struct {
+	relations schema.RelSet
+	logical   Logical
+	exprs     []ExprID
 }
 
 type expr struct {
-	j  *Forest
-	id GroupID
+	j     *Forest
+	id    ExprID
+	group GroupID
+
+	op Operator
 
 	// relID is 0 if this is not a leaf expr.
 	relID schema.RelationID
 
-	// l and r are 0 if this is a leaf expr.
-	l GroupID
-	r GroupID
+	// l and r are 0 if this is a leaf expr. r is also 0 for a sort, an
+	// exchange, a distinct or a group by.
+	l ExprID
+	r ExprID
 
-	relations schema.RelSet
+	phys Physical
 }
 
 func NewForest(s *schema.Schema) *Forest {
 	return &Forest{
-		s:     s,
-		exprs: make([]expr, 1),
+		s:        s,
+		exprs:    make([]expr, 1),
+		groups:   make([]group, 1),
+		groupIdx: make(map[Logical]*schema.RelSetMap),
+	}
+}
+
+// add adds e to the forest, and to the group for relations and l, which is
+// created if it doesn't exist.
+func (j *Forest) add(e expr, relations schema.RelSet, l Logical) ExprID {
+	e.j = j
+	e.id = ExprID(len(j.exprs))
+	e.group, _ = j.Group(relations, l)
+	if e.group == 0 {
+		e.group = GroupID(len(j.groups))
+		j.groups = append(j.groups, group{relations: relations, logical: l})
+		if j.groupIdx[l] == nil {
+			j.groupIdx[l] = schema.NewRelSetMap()
+		}
+		j.groupIdx[l].Set(relations, int(e.group))
+	}
+	j.exprs = append(j.exprs, e)
+	j.groups[e.group].exprs = append(j.groups[e.group].exprs, e.id)
+	return e.id
+}
+
+func (j *Forest) AddLeaf(r schema.RelationID) ExprID {
+	return j.add(expr{
+		op:    Scan,
+		relID: r,
+		phys:  Physical{Distribution: j.s.Distribution(r)},
+	}, util.MakeFastIntSet(int(r)), Logical{})
+}
+
+// AddJoin adds a hash join of l and r. Its output is in no particular order.
+func (j *Forest) AddJoin(l, r ExprID) ExprID {
+	return j.add(expr{
+		op:   HashJoin,
+		l:    l,
+		r:    r,
+		phys: j.joinPhysical(l, r, 0),
+	}, j.GetMembers(l).Union(j.GetMembers(r)), j.joinLogical(l, r))
+}
+
+// AddMergeJoin adds a merge join of l and r on the join key o. Both inputs
+// must be sorted on o, and so is the output.
+func (j *Forest) AddMergeJoin(l, r ExprID, o schema.JoinKey) ExprID {
+	if j.exprs[l].phys.Ordering != o || j.exprs[r].phys.Ordering != o {
+		panic(fmt.Sprintf("merge join inputs are not sorted on %d", int(o)))
+	}
+	return j.add(expr{
+		op:   MergeJoin,
+		l:    l,
+		r:    r,
+		phys: j.joinPhysical(l, r, o),
+	}, j.GetMembers(l).Union(j.GetMembers(r)), j.joinLogical(l, r))
+}
+
+func (j *Forest) joinPhysical(l, r ExprID, o schema.JoinKey) Physical {
+	le, re := &j.exprs[l], &j.exprs[r]
+	d, ok := j.s.JoinDistribution(j.GetMembers(l), j.GetMembers(r), le.phys.Distribution, re.phys.Distribution)
+	if !ok {
+		panic(fmt.Sprintf(
+			"can't join %s and %s rows without an exchange", le.phys.Distribution, re.phys.Distribution,
+		))
+	}
+	return Physical{Ordering: o, Distribution: d}
+}
+
+// joinLogical returns the Logical properties of a join of l and r: it is
+// grouped if either input is, and has duplicates again.
+func (j *Forest) joinLogical(l, r ExprID) Logical {
+	return Logical{Grouped: j.Logical(l).Grouped || j.Logical(r).Grouped}
+}
+
+// AddSort adds a sort of the rows of g on o.
+func (j *Forest) AddSort(g ExprID, o schema.JoinKey) ExprID {
+	phys := j.exprs[g].phys
+	phys.Ordering = o
+	return j.add(expr{op: Sort, l: g, phys: phys}, j.GetMembers(g), j.Logical(g))
+}
+
+// AddExchange adds an exchange which moves the rows of g between nodes so
+// that they are distributed as d. Its output is in no particular order.
+func (j *Forest) AddExchange(g ExprID, d schema.Distribution) ExprID {
+	phys := Physical{Distribution: d}
+	return j.add(expr{op: Exchange, l: g, phys: phys}, j.GetMembers(g), j.Logical(g))
+}
+
+// AddDistinct adds a removal of the rows of g which are duplicates on the
+// values compared by the semi join g is the right side of. Its output is in
+// no particular order.
+func (j *Forest) AddDistinct(g ExprID) ExprID {
+	phys := Physical{Distribution: j.exprs[g].phys.Distribution}
+	l := j.Logical(g)
+	l.Deduplicated = true
+	return j.add(expr{op: Distinct, l: g, phys: phys}, j.GetMembers(g), l)
+}
+
+// AddGroupBy adds an aggregation of the rows of g on the columns of the
+// query's GROUP BY which belong to them, and those they are joined on to other
+// relations. Its output is in no particular order.
+func (j *Forest) AddGroupBy(g ExprID) ExprID {
+	phys := Physical{Distribution: j.exprs[g].phys.Distribution}
+	l := j.Logical(g)
+	l.Grouped = true
+	return j.add(expr{op: GroupBy, l: g, phys: phys}, j.GetMembers(g), l)
+}
+
+// Schema returns the schema of the relations being joined.
+func (j *Forest) Schema() *schema.Schema {
+	return j.s
+}
+
+// Relation returns the relation scanned by g, or 0 if g is not a leaf.
+func (j *Forest) Relation(g ExprID) schema.RelationID {
+	return j.exprs[g].relID
+}
+
+// Children returns the inputs of g. Both are 0 if g is a leaf, and r is 0 if g
+// is a sort, an exchange, a distinct or a group by.
+func (j *Forest) Children(g ExprID) (l, r ExprID) {
+	return j.exprs[g].l, j.exprs[g].r
+}
+
+func (j *Forest) GetMembers(g ExprID) schema.RelSet {
+	return j.groups[j.exprs[g].group].relations
+}
+
+// Group returns the group for set with the Logical properties l, and false if
+// no such expr has been added.
+func (j *Forest) Group(set schema.RelSet, l Logical) (GroupID, bool) {
+	m := j.groupIdx[l]
+	if m == nil {
+		return 0, false
+	}
+	g := GroupID(m.Get(set))
+	return g, g != 0
+}
+
+// GroupOf returns the group g belongs to.
+func (j *Forest) GroupOf(g ExprID) GroupID {
+	return j.exprs[g].group
+}
+
+// Groups returns every group, in the order they were created.
+func (j *Forest) Groups() []GroupID {
+	groups := make([]GroupID, 0, len(j.groups)-1)
+	for g := GroupID(1); int(g) < len(j.groups); g++ {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// GroupMembers returns the set of relations joined by the exprs of g.
+func (j *Forest) GroupMembers(g GroupID) schema.RelSet {
+	return j.groups[g].relations
+}
+
+// GroupLogical returns the Logical properties of the rows of g.
+func (j *Forest) GroupLogical(g GroupID) Logical {
+	return j.groups[g].logical
+}
+
+// Exprs returns the exprs of g which haven't been removed, in the order they
+// were added. The result must not be modified.
+func (j *Forest) Exprs(g GroupID) []ExprID {
+	return j.groups[g].exprs
+}
+
+// Remove removes g from its group, so that it is no longer one of the
+// alternatives for its relations. Plans which already use g are unaffected.
+func (j *Forest) Remove(g ExprID) {
+	grp := &j.groups[j.exprs[g].group]
+	for i, e := range grp.exprs {
+		if e == g {
+			grp.exprs = append(grp.exprs[:i:i], grp.exprs[i+1:]...)
+			return
+		}
+	}
+}
+
+// IsCrossProduct returns whether g is a join of inputs which have no predicate
+// between them, as happens when the query graph is disconnected.
+func (j *Forest) IsCrossProduct(g ExprID) bool {
+	e := &j.exprs[g]
+	if e.op != HashJoin && e.op != MergeJoin {
+		return false
 	}
+	return !j.s.SubgraphsAdjacent(j.GetMembers(e.l), j.GetMembers(e.r))
 }
 
-func (j *Forest) AddLeaf(r schema.RelationID) GroupID {
-	id := GroupID(len(j.exprs))
-	j.exprs = append(j.exprs, expr{
-		j:         j,
-		id:        id,
-		relID:     r,
-		relations: util.MakeFastIntSet(int(r)),
-	})
+// JoinKind returns the kind of the join g, or InnerJoin if g is not a join. A
+// semi join whose right side has had its duplicates removed is performed as
+// an inner join.
+func (j *Forest) JoinKind(g ExprID) schema.JoinKind {
+	e := &j.exprs[g]
+	if e.op != HashJoin && e.op != MergeJoin {
+		return schema.InnerJoin
+	}
+	k := j.s.JoinKind(j.GetMembers(e.l), j.GetMembers(e.r))
+	if k == schema.SemiJoin && (j.Logical(e.l).Deduplicated || j.Logical(e.r).Deduplicated) {
+		return schema.InnerJoin
+	}
+	return k
+}
 
-	return id
+// Operator returns the physical operator of g.
+func (j *Forest) Operator(g ExprID) Operator {
+	return j.exprs[g].op
 }
 
-func (j *Forest) AddJoin(l, r GroupID) GroupID {
-	id := GroupID(len(j.exprs))
-	j.exprs = append(j.exprs, expr{
-		j:         j,
-		id:        id,
-		l:         l,
-		r:         r,
-		relations: j.exprs[l].relations.Union(j.exprs[r].relations),
-	})
-	return id
+// Physical returns the physical properties of the rows produced by g.
+func (j *Forest) Physical(g ExprID) Physical {
+	return j.exprs[g].phys
 }
 
-func (j *Forest) GetMembers(g GroupID) schema.RelSet {
-	return j.exprs[g].relations
+// Logical returns the Logical properties of the rows produced by g, which are
+// those of its group.
+func (j *Forest) Logical(g ExprID) Logical {
+	return j.groups[j.exprs[g].group].logical
 }
 
-func (j *Forest) AsJoin(g GroupID) Join {
+func (j *Forest) AsJoin(g ExprID) Join {
 	return Join{
 		forest: j,
 		root:   g,
 	}
 }
 
-func (j *Forest) FormatString(g GroupID) string {
+func (j *Forest) FormatString(g ExprID) string {
 	var buf bytes.Buffer
 	j.format(g, &buf)
 	return buf.String()
 }
 
+// String returns each group, followed by the exprs it holds.
 func (j *Forest) String() string {
 	var buf bytes.Buffer
 
-	for i, g := range j.exprs {
-		if i == 0 {
-			continue
+	for g := GroupID(1); int(g) < len(j.groups); g++ {
+		fmt.Fprintf(&buf, "G%d %s\n", g, j.groupString(g))
+		for _, id := range j.groups[g].exprs {
+			e := j.exprs[id]
+			fmt.Fprintf(&buf, "  E%d - ", id)
+			switch e.op {
+			case Scan:
+				fmt.Fprintf(&buf, "[%s]", j.leafString(e.relID))
+			case Sort, Exchange, Distinct, GroupBy:
+				fmt.Fprintf(&buf, "%s(E%d)", unarySymbol(e), e.l)
+			default:
+				fmt.Fprintf(&buf, "E%d %s E%d", e.l, j.joinSymbol(e), e.r)
+			}
+			buf.WriteByte('\n')
 		}
-		fmt.Fprintf(&buf, "G%d - ", i)
-		if g.relID != 0 {
-			fmt.Fprintf(&buf, "[%s]", j.s.Relation(g.relID).Name)
-		} else {
-			fmt.Fprintf(&buf, "G%d ⋈ G%d", g.l, g.r)
-		}
-		buf.WriteByte('\n')
 	}
 	return buf.String()
 }
 
-func (j *Forest) format(g GroupID, buf *bytes.Buffer) {
+func (j *Forest) format(g ExprID, buf *bytes.Buffer) {
 	if g == 0 {
 		panic("zero expr")
 	}
 	expr := j.exprs[g]
-	if expr.relID != 0 {
-		buf.WriteString(string(j.s.Relation(expr.relID).Name))
-	} else {
+	switch expr.op {
+	case Scan:
+		buf.WriteString(j.leafString(expr.relID))
+	case Sort, Exchange, Distinct, GroupBy:
+		buf.WriteString(unarySymbol(expr))
+		buf.WriteByte('(')
+		j.format(expr.l, buf)
+		buf.WriteByte(')')
+	default:
 		buf.WriteByte('(')
 		j.format(expr.l, buf)
-		buf.WriteString(" ⋈ ")
+		fmt.Fprintf(buf, " %s ", j.joinSymbol(expr))
 		j.format(expr.r, buf)
 		buf.WriteByte(')')
 	}
 }
+
+// groupString returns how the rows of g are displayed: its relations, marked
+// with γ if they are grouped and δ if they are deduplicated.
+func (j *Forest) groupString(g GroupID) string {
+	var prefix string
+	if j.groups[g].logical.Grouped {
+		prefix += "γ"
+	}
+	if j.groups[g].logical.Deduplicated {
+		prefix += "δ"
+	}
+	return prefix + j.groups[g].relations.String()
+}
+
+// leafString returns how a scan of r is displayed: its name, marked with σ if
+// it is filtered.
+func (j *Forest) leafString(r schema.RelationID) string {
+	name := string(j.s.Relation(r).Name)
+	if len(j.s.Filters(r)) > 0 {
+		return "σ(" + name + ")"
+	}
+	return name
+}
+
+func unarySymbol(e expr) string {
+	switch e.op {
+	case Sort:
+		return "sort"
+	case Distinct:
+		return "δ"
+	case GroupBy:
+		return "γ"
+	}
+	switch e.phys.Distribution.Kind {
+	case schema.Singleton:
+		return "gather"
+	case schema.Replicated:
+		return "broadcast"
+	}
+	return "shuffle"
+}
+
+// joinSymbol returns how a join is displayed. Cross products are shown as ×,
+// and merge joins are marked with ₘ.
+func (j *Forest) joinSymbol(e expr) string {
+	if j.IsCrossProduct(e.id) {
+		return "×"
+	}
+	var sym string
+	switch j.JoinKind(e.id) {
+	case schema.LeftOuterJoin:
+		sym = "⟕"
+	case schema.RightOuterJoin:
+		sym = "⟖"
+	case schema.FullOuterJoin:
+		sym = "⟗"
+	case schema.SemiJoin:
+		sym = "⋉"
+	case schema.AntiJoin:
+		sym = "▷"
+	default:
+		sym = "⋈"
+	}
+	if e.op == MergeJoin {
+		sym += "ₘ"
+	}
+	return sym
+}
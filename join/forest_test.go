@@ -1,6 +1,7 @@
 package join
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/justinj/joinorder/schema"
@@ -22,7 +23,7 @@ func makeTestSchema() *schema.Schema {
 	builder.AddPredicate(c, e, 0.05)
 	builder.AddPredicate(e, f, 0.0001)
 
-	return builder.Build()
+	return builder.MustBuild()
 }
 
 func TestJoin(t *testing.T) {
@@ -55,7 +56,167 @@ func TestJoin(t *testing.T) {
 
 	root := j1
 
-	if j.FormatString(root) != "((B ⋈ (F ⋈ (E ⋈ D))) ⋈ (C ⋈ A))" {
+	// E and D, and C and A, have no predicates between them.
+	if j.FormatString(root) != "((B ⋈ (F ⋈ (E × D))) ⋈ (C × A))" {
 		t.Fatal("wrong stringified output")
 	}
+	if !j.IsCrossProduct(j5) || j.IsCrossProduct(j4) || j.IsCrossProduct(a) {
+		t.Fatal("wrong cross products")
+	}
+}
+
+func TestDOT(t *testing.T) {
+	s := makeTestSchema()
+	j := NewForest(s)
+
+	a := j.AddLeaf(s.GetRelationByName("A"))
+	b := j.AddLeaf(s.GetRelationByName("B"))
+	c := j.AddLeaf(s.GetRelationByName("C"))
+	ab := j.AddJoin(a, b)
+	root := j.AddJoin(ab, c)
+	j.AddJoin(c, ab)
+
+	expected := `digraph G {
+  e5 [label="⋈"];
+  e5 -> e4;
+  e5 -> e3;
+  e4 [label="⋈"];
+  e4 -> e1;
+  e4 -> e2;
+  e1 [label="A"];
+  e2 [label="B"];
+  e3 [label="C"];
+}
+`
+	if actual := j.AsJoin(root).DOT(nil); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	// The forest includes both plans, which share the join of A and B, and
+	// are in the same group.
+	expected = `digraph G {
+  subgraph cluster_g1 {
+    label="(1)";
+    e1;
+  }
+  subgraph cluster_g2 {
+    label="(2)";
+    e2;
+  }
+  subgraph cluster_g3 {
+    label="(3)";
+    e3;
+  }
+  subgraph cluster_g4 {
+    label="(1,2)";
+    e4;
+  }
+  subgraph cluster_g5 {
+    label="(1-3)";
+    e5;
+    e6;
+  }
+  e1 [label="A"];
+  e2 [label="B"];
+  e3 [label="C"];
+  e4 [label="⋈"];
+  e4 -> e1;
+  e4 -> e2;
+  e5 [label="⋈"];
+  e5 -> e4;
+  e5 -> e3;
+  e6 [label="⋈"];
+  e6 -> e3;
+  e6 -> e4;
+}
+`
+	if actual := j.DOT(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	s := makeTestSchema()
+	j := NewForest(s)
+
+	a := j.AddLeaf(s.GetRelationByName("A"))
+	b := j.AddLeaf(s.GetRelationByName("B"))
+	ab := j.AddJoin(a, b)
+	ba := j.AddJoin(b, a)
+	sorted := j.AddSort(ab, 1)
+
+	g, ok := j.Group(j.GetMembers(ab), Logical{})
+	if !ok || g != j.GroupOf(ba) || g != j.GroupOf(sorted) || g == j.GroupOf(a) {
+		t.Fatal("expected every join of A and B to be in one group")
+	}
+	c := j.AddLeaf(s.GetRelationByName("C"))
+	if _, ok := j.Group(j.GetMembers(a).Union(j.GetMembers(c)), Logical{}); ok {
+		t.Fatal("expected no group for A and C")
+	}
+	if len(j.Groups()) != 4 {
+		t.Fatalf("expected 4 groups, got %d", len(j.Groups()))
+	}
+
+	// The sort still uses the removed join.
+	j.Remove(ab)
+	if exprs := j.Exprs(g); len(exprs) != 2 || exprs[0] != ba || exprs[1] != sorted {
+		t.Fatalf("expected the remaining exprs to be (B ⋈ A) and its sort, got %v", exprs)
+	}
+	if !strings.Contains(j.DOT(), "    e3 [style=dashed];\n") {
+		t.Fatalf("expected the removed join to be dashed, got\n%s", j.DOT())
+	}
+
+	expected := `G1 (1)
+  E1 - [A]
+G2 (2)
+  E2 - [B]
+G3 (1,2)
+  E4 - E2 ⋈ E1
+  E5 - sort(E3)
+G4 (3)
+  E6 - [C]
+`
+	if j.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, j.String())
+	}
+}
+
+func TestLogicalGroups(t *testing.T) {
+	s := makeTestSchema()
+	j := NewForest(s)
+
+	a := j.AddLeaf(s.GetRelationByName("A"))
+	b := j.AddLeaf(s.GetRelationByName("B"))
+	grouped := j.AddGroupBy(a)
+	distinct := j.AddDistinct(b)
+
+	// Aggregated and deduplicated rows aren't alternatives to the ones they
+	// came from.
+	if j.GroupOf(grouped) == j.GroupOf(a) || j.GroupOf(distinct) == j.GroupOf(b) {
+		t.Fatal("expected γ(A) and δ(B) to be in groups of their own")
+	}
+	if g, ok := j.Group(j.GetMembers(a), Logical{Grouped: true}); !ok || g != j.GroupOf(grouped) {
+		t.Fatal("expected to find the group of γ(A)")
+	}
+
+	// A join is grouped if either input is, but has duplicates again.
+	ab := j.AddJoin(grouped, distinct)
+	if l := j.Logical(ab); l != (Logical{Grouped: true}) {
+		t.Fatalf("expected the join to be grouped and not deduplicated, got %+v", l)
+	}
+
+	expected := `G1 (1)
+  E1 - [A]
+G2 (2)
+  E2 - [B]
+G3 γ(1)
+  E3 - γ(E1)
+G4 δ(2)
+  E4 - δ(E2)
+G5 γ(1,2)
+  E5 - E3 ⋈ E4
+`
+	if j.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, j.String())
+	}
 }
@@ -2,7 +2,17 @@ package join
 
 type Join struct {
 	forest *Forest
-	root   GroupID
+	root   ExprID
+}
+
+// Forest returns the Forest the plan was built in.
+func (j Join) Forest() *Forest {
+	return j.forest
+}
+
+// Root returns the root expr of the plan.
+func (j Join) Root() ExprID {
+	return j.root
 }
 
 func (j Join) String() string {
@@ -33,18 +33,36 @@ func (o *Orderer) BruteForceOrder() Sequence {
 	return best
 }
 
+// Cost returns the total number of rows produced by scanning the first
+// relation of ord and joining each of the others to it in turn.
 func (o *Orderer) Cost(ord Sequence) float64 {
-	cost := float64(o.s.Cardinality(ord[0]))
-	numRows := float64(o.s.Cardinality(ord[0]))
+	cost := float64(0)
+	o.joinInOrder(ord, func(numRows schema.Cardinality) {
+		cost += float64(numRows)
+	})
+	return cost
+}
+
+// Cardinality returns the number of rows produced by joining the relations of
+// ord.
+func (o *Orderer) Cardinality(ord Sequence) schema.Cardinality {
+	var card schema.Cardinality
+	o.joinInOrder(ord, func(numRows schema.Cardinality) {
+		card = numRows
+	})
+	return card
+}
 
+// joinInOrder joins the relations of ord left-deep, in order, and calls f
+// with the number of rows of the first relation and of each join after it.
+func (o *Orderer) joinInOrder(ord Sequence, f func(numRows schema.Cardinality)) {
+	numRows := o.s.Cardinality(ord[0])
+	prefix := schema.S(ord[0])
+	f(numRows)
 	for i := 1; i < len(ord); i++ {
-		// Calculate selectivity of this relation with all
-		// previous relations.
-		for j := 0; j < i; j++ {
-			numRows *= float64(o.s.Selectivity(ord[i], ord[j]))
-		}
-		numRows *= float64(o.s.Cardinality(ord[i]))
-		cost += numRows
+		next := schema.S(ord[i])
+		numRows = o.s.JoinCardinality(prefix, next, numRows, o.s.Cardinality(ord[i]))
+		prefix.UnionWith(next)
+		f(numRows)
 	}
-	return cost
 }
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"testing"
 
+	"github.com/justinj/joinorder/cost"
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/queries"
 	"github.com/justinj/joinorder/schema"
 )
 
@@ -28,7 +33,7 @@ func TestCosting(t *testing.T) {
 	builder.AddPredicate(a, b, 0.01)
 	builder.AddPredicate(b, c, 0.1)
 
-	s := builder.Build()
+	s := builder.MustBuild()
 
 	o := NewOrderer(s)
 
@@ -74,7 +79,7 @@ func TestBiggerJoin(t *testing.T) {
 	builder.AddPredicate(c, e, 0.05)
 	builder.AddPredicate(e, f, 0.0001)
 
-	o := NewOrderer(builder.Build())
+	o := NewOrderer(builder.MustBuild())
 
 	expected := Sequence{2, 4, 1, 3, 5, 6}
 	actual := o.BruteForceOrder()
@@ -103,7 +108,7 @@ func makeTestSchema() *schema.Schema {
 	builder.AddPredicate(c, e, 0.05)
 	builder.AddPredicate(e, f, 0.0001)
 
-	return builder.Build()
+	return builder.MustBuild()
 }
 
 func TestDPSizeOrderer(t *testing.T) {
@@ -118,7 +123,7 @@ func TestDPSizeOrderer(t *testing.T) {
 	builder.AddPredicate(a, c, 0.5)
 	builder.AddPredicate(c, d, 0.0000001)
 
-	s := builder.Build()
+	s := builder.MustBuild()
 
 	o := NewDPSizeOrderer(s)
 
@@ -229,3 +234,565 @@ func TestIKKBZOrderer(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected, actual)
 	}
 }
+
+func TestIKKBZOrdererLargeQuery(t *testing.T) {
+	// A chain of more relations than fit in a word.
+	builder := schema.NewBuilder()
+	prev := builder.AddRelation("R0", 1000)
+	for i := 1; i < 70; i++ {
+		r := builder.AddRelation(schema.RelationName(fmt.Sprintf("R%d", i)), schema.Cardinality(1000+i))
+		builder.AddPredicate(prev, r, 0.001)
+		prev = r
+	}
+
+	j := NewIKKBZOrderer(builder.MustBuild()).Order()
+	f := j.Forest()
+	members := f.GetMembers(j.Root())
+	if members.Len() != 70 {
+		t.Fatalf("expected a plan joining 70 relations, got %s", members)
+	}
+	if g, ok := f.Group(members, join.Logical{}); !ok || g != f.GroupOf(j.Root()) {
+		t.Fatal("expected the plan to be in the group for every relation")
+	}
+}
+
+func TestIKKBZOrdererCompositeRightSides(t *testing.T) {
+	for _, tc := range []struct {
+		kind     schema.JoinKind
+		expected string
+	}{
+		{schema.SemiJoin, "(A ⋉ (B ⋈ C))"},
+		{schema.AntiJoin, "(A ▷ (B ⋈ C))"},
+		{schema.LeftOuterJoin, "(A ⟕ (B ⋈ C))"},
+	} {
+		builder := schema.NewBuilder()
+		a := builder.AddRelation("A", 10)
+		b := builder.AddRelation("B", 1000)
+		c := builder.AddRelation("C", 1000)
+		builder.AddPredicate(a, b, 0.001)
+		builder.AddPredicate(b, c, 0.001)
+		// No left-deep order joins B and C before joining them to A.
+		switch tc.kind {
+		case schema.SemiJoin:
+			builder.AddSemiJoin(schema.S(a), schema.S(b, c))
+		case schema.AntiJoin:
+			builder.AddAntiJoin(schema.S(a), schema.S(b, c))
+		default:
+			builder.AddOuterJoin(tc.kind, schema.S(a), schema.S(b, c))
+		}
+
+		if j := NewIKKBZOrderer(builder.MustBuild()).Order(); j.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.kind, tc.expected, j)
+		}
+	}
+}
+
+func TestDPSizeOrdererInterestingOrders(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 2000)
+	c := builder.AddRelation("C", 500)
+
+	// A.x = B.x AND B.x = C.x
+	x := builder.AddPredicate(a, b, 0.01)
+	builder.AddPredicateOnKey(b, c, 0.01, x)
+
+	expected := "(A ⋈ (B ⋈ C))"
+	if j := NewDPSizeOrderer(builder.MustBuild()).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	// ORDER BY x
+	builder.SetOrderBy(x)
+
+	// Sorting the inputs is much cheaper than sorting the output, and the
+	// output of the first merge join can be fed straight into the second.
+	expected = "(sort(A) ⋈ₘ (sort(B) ⋈ₘ sort(C)))"
+	if j := NewDPSizeOrderer(builder.MustBuild()).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+}
+
+func TestDPSizeOrdererDistributed(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	f := builder.AddRelation("F", 1000000)
+	d1 := builder.AddRelation("D1", 100)
+	d2 := builder.AddRelation("D2", 100000)
+
+	builder.AddPredicate(f, d1, 0.001)
+	k := builder.AddPredicate(f, d2, 0.00001)
+
+	// F and D2 are partitioned on the key they are joined on, D1 is spread
+	// over the cluster arbitrarily.
+	builder.SetDistribution(f, schema.HashedOn(k))
+	builder.SetDistribution(d2, schema.HashedOn(k))
+	builder.SetDistribution(d1, schema.Distribution{Kind: schema.Random})
+
+	o := NewDPSizeOrderer(builder.MustBuild())
+	o.SetCostModel(cost.Distributed{Nodes: 10, Network: 1})
+
+	// D1 is small enough that broadcasting it is cheaper than shuffling F,
+	// and joining with it leaves F partitioned so that it can be joined with
+	// D2 without moving either.
+	expected := "gather((D2 ⋈ (F ⋈ broadcast(D1))))"
+	if j := o.Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+}
+
+func TestOrderersExchange(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 100)
+	k := builder.AddPredicate(a, b, 0.01)
+
+	// A is partitioned on the key it is joined on, and B isn't, so one of them
+	// has to be moved before they can be joined.
+	builder.SetDistribution(a, schema.HashedOn(k))
+	builder.SetDistribution(b, schema.Distribution{Kind: schema.Random})
+	s := builder.MustBuild()
+
+	for _, tc := range []struct {
+		name     string
+		order    func() join.Join
+		expected string
+	}{
+		{"IKKBZOrderer", NewIKKBZOrderer(s).Order, "gather((shuffle(B) ⋈ A))"},
+		{"ParetoOrderer", NewParetoOrderer(s).Order, "gather((A ⋈ shuffle(B)))"},
+	} {
+		if j := tc.order(); j.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, j)
+		}
+	}
+}
+
+func TestOrderersFinishQuery(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 100)
+	c := builder.AddRelation("C", 10)
+	builder.AddPredicate(a, b, 0.01)
+	k := builder.AddPredicate(b, c, 0.1)
+	g := builder.AddColumn(a, "g")
+	builder.SetColumnStats(g, schema.ColumnStats{DistinctCount: 10})
+	builder.SetGroupBy(g)
+	builder.SetOrderBy(k)
+	s := builder.MustBuild()
+
+	// Every orderer aggregates and sorts the result of the joins.
+	for _, tc := range []struct {
+		name     string
+		order    func() join.Join
+		expected string
+	}{
+		{"DPSizeOrderer", NewDPSizeOrderer(s).Order, "sort(γ((A ⋈ (B ⋈ C))))"},
+		{"IKKBZOrderer", NewIKKBZOrderer(s).Order, "sort(γ(((C ⋈ B) ⋈ A)))"},
+		{"ParetoOrderer", NewParetoOrderer(s).Order, "sort(γ((A ⋈ (B ⋈ C))))"},
+	} {
+		if j := tc.order(); j.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, j)
+		}
+	}
+}
+
+func TestParetoOrderer(t *testing.T) {
+	o := NewParetoOrderer(queries.Bushy())
+
+	frontier := o.Frontier()
+	if len(frontier) != 4 {
+		t.Fatalf("expected 4 plans on the frontier, got %d", len(frontier))
+	}
+	for i := range frontier {
+		for j := range frontier {
+			if i != j && frontier[i].Cost.Dominates(frontier[j].Cost) {
+				t.Fatalf("%s dominates %s", frontier[i].Join, frontier[j].Join)
+			}
+		}
+	}
+
+	// The bushy plan does the least work, but builds a hash table over a join
+	// rather than a base relation, so it needs the most memory.
+	expected := "((A ⋈ B) ⋈ (C ⋈ D))"
+	if j := o.Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	lowest := frontier[0]
+	for _, p := range frontier {
+		if p.Cost[cost.Memory] < lowest.Cost[cost.Memory] {
+			lowest = p
+		}
+	}
+	expected = "(((A ⋈ B) ⋈ C) ⋈ D)"
+	if lowest.Join.String() != expected || lowest.Cost[cost.Memory] != 2400 {
+		t.Fatalf("expected %q to use the least memory, got %q using %v", expected, lowest.Join, lowest.Cost[cost.Memory])
+	}
+}
+
+func TestParametricOrderer(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 1000)
+
+	builder.AddPredicate(a, b, 0.001)
+	builder.AddPredicate(b, c, 0.001)
+	builder.SetParameter(a, b, 0.00001, 0.1)
+
+	o := NewParametricOrderer(builder.MustBuild())
+	plans := o.Plans()
+	if len(plans) != 2 {
+		t.Fatalf("expected 2 plans, got %d", len(plans))
+	}
+
+	// When A ⋈ B is more selective than B ⋈ C it should be done first, and
+	// otherwise B ⋈ C should be.
+	cases := []struct {
+		expected string
+		lo, hi   schema.Selectivity
+	}{
+		{"(C ⋈ (A ⋈ B))", 0.00001, 0.0001},
+		{"(A ⋈ (B ⋈ C))", 0.001, 0.1},
+	}
+	for i, tc := range cases {
+		if plans[i].Join.String() != tc.expected {
+			t.Fatalf("expected %q, got %q", tc.expected, plans[i].Join)
+		}
+		lo, hi := plans[i].Bounds()
+		if math.Abs(float64(lo[0]-tc.lo)) > 1e-9 || math.Abs(float64(hi[0]-tc.hi)) > 1e-9 {
+			t.Fatalf("expected %q to cover [%v, %v], got [%v, %v]", tc.expected, tc.lo, tc.hi, lo[0], hi[0])
+		}
+	}
+
+	if j := o.Order(); j.String() != "(A ⋈ (B ⋈ C))" {
+		t.Fatalf("expected the plan covering the most points, got %q", j)
+	}
+}
+
+func TestRobustOrderer(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 1000)
+
+	builder.AddPredicate(a, b, 0.001)
+	builder.AddPredicate(b, c, 0.002)
+	builder.SetSelectivityError(a, b, 100)
+
+	s := builder.MustBuild()
+
+	expected := "(C ⋈ (A ⋈ B))"
+	if j := NewDPSizeOrderer(s).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	// A ⋈ B is estimated to be more selective, but it could well be much
+	// less selective than B ⋈ C, so it's safer to do B ⋈ C first.
+	o := NewRobustOrderer(s)
+	expected = "(A ⋈ (B ⋈ C))"
+	if j := o.Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	o.SetWorstCase(true)
+	if j := o.Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	report := o.Report()
+	if len(report) != 2 {
+		t.Fatalf("expected 2 candidates, got %d", len(report))
+	}
+	if report[0].Variance >= report[1].Variance {
+		t.Fatalf("expected %q to have lower variance than %q", report[0].Join, report[1].Join)
+	}
+}
+
+func TestCardinalityOverrides(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 1000)
+
+	builder.AddPredicate(a, b, 0.001)
+	builder.AddPredicate(b, c, 0.002)
+
+	expected := "(C ⋈ (A ⋈ B))"
+	if j := NewDPSizeOrderer(builder.MustBuild()).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	// A ⋈ B turns out to be much bigger than the independence assumption
+	// predicts.
+	builder.SetCardinality(schema.S(a, b), 100000)
+	s := builder.MustBuild()
+
+	expected = "(A ⋈ (B ⋈ C))"
+	if j := NewDPSizeOrderer(s).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	expected = "((C ⋈ B) ⋈ A)"
+	if j := NewIKKBZOrderer(s).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+
+	j := NewParetoOrderer(s).Order()
+	if ann := cost.Annotate(j, cost.Local{}); ann.Root().Card != 2000 || ann.Root().Total != 4000 {
+		t.Fatalf("expected %s to produce 2000 rows at a cost of 4000, got %v", j, ann.Root())
+	}
+}
+
+func TestDPSizeOrdererImpliedEqualities(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 10)
+	b := builder.AddRelation("B", 1000000)
+	c := builder.AddRelation("C", 10)
+
+	ax := builder.AddColumn(a, "x")
+	bx := builder.AddColumn(b, "x")
+	cx := builder.AddColumn(c, "x")
+
+	builder.AddEquality(ax, bx, 0.000001)
+	builder.AddEquality(bx, cx, 0.000001)
+
+	// A and C aren't joined directly, but A.x = C.x is implied, so they can
+	// be joined before the much larger B.
+	expected := "(B ⋈ (A ⋈ C))"
+	if j := NewDPSizeOrderer(builder.MustBuild()).Order(); j.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, j)
+	}
+}
+
+func TestDPSizeOrdererJointSelectivity(t *testing.T) {
+	for _, tc := range []struct {
+		joint    bool
+		expected string
+	}{
+		{false, "(C ⋈ (A ⋈ B))"},
+		{true, "(A ⋈ (B ⋈ C))"},
+	} {
+		builder := schema.NewBuilder()
+
+		a := builder.AddRelation("A", 1000)
+		b := builder.AddRelation("B", 1000)
+		c := builder.AddRelation("C", 1000)
+
+		ax, ay := builder.AddColumn(a, "x"), builder.AddColumn(a, "y")
+		bx, by := builder.AddColumn(b, "x"), builder.AddColumn(b, "y")
+		bz, cz := builder.AddColumn(b, "z"), builder.AddColumn(c, "z")
+
+		ex := builder.AddEquality(ax, bx, 0.01)
+		ey := builder.AddEquality(ay, by, 0.01)
+		builder.AddEquality(bz, cz, 0.001)
+		// If x and y are perfectly correlated, A and B don't make a good first
+		// join after all.
+		if tc.joint {
+			builder.SetJointSelectivity(0.01, ex, ey)
+		}
+
+		if j := NewDPSizeOrderer(builder.MustBuild()).Order(); j.String() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, j)
+		}
+	}
+}
+
+func TestDPSizeOrdererFilters(t *testing.T) {
+	for _, tc := range []struct {
+		filter   bool
+		expected string
+	}{
+		{false, "(A ⋈ (B ⋈ C))"},
+		{true, "(C ⋈ (σ(A) ⋈ B))"},
+	} {
+		builder := schema.NewBuilder()
+
+		a := builder.AddRelation("A", 1000)
+		b := builder.AddRelation("B", 100)
+		c := builder.AddRelation("C", 100)
+
+		builder.AddPredicate(a, b, 0.01)
+		builder.AddPredicate(b, c, 0.01)
+		// A filter small enough makes A the better relation to start with.
+		if tc.filter {
+			builder.AddFilter(a, "a.status = 'x'", 0.001)
+		}
+
+		if j := NewDPSizeOrderer(builder.MustBuild()).Order(); j.String() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, j)
+		}
+	}
+}
+
+func TestDisconnectedQueryGraph(t *testing.T) {
+	builder := schema.NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 100)
+	c := builder.AddRelation("C", 10)
+	d := builder.AddRelation("D", 50)
+	builder.AddRelation("E", 5)
+
+	builder.AddPredicate(a, b, 0.01)
+	builder.AddPredicate(c, d, 0.1)
+
+	s := builder.MustBuild()
+
+	for _, tc := range []struct {
+		name     string
+		order    func() fmt.Stringer
+		expected string
+	}{
+		{"dpsize", func() fmt.Stringer { return NewDPSizeOrderer(s).Order() }, "((E × (C ⋈ D)) × (A ⋈ B))"},
+		{"ikkbz", func() fmt.Stringer { return NewIKKBZOrderer(s).Order() }, "((E × (C ⋈ D)) × (B ⋈ A))"},
+		{"pareto", func() fmt.Stringer { return NewParetoOrderer(s).Order() }, "((A ⋈ B) × ((D ⋈ C) × E))"},
+	} {
+		if j := tc.order(); j.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, j)
+		}
+	}
+}
+
+func TestDPSizeOrdererOuterJoins(t *testing.T) {
+	for _, tc := range []struct {
+		outer    bool
+		expected string
+	}{
+		{false, "(C ⋈ (A ⋈ B))"},
+		{true, "(A ⟕ (B ⋈ C))"},
+	} {
+		builder := schema.NewBuilder()
+
+		a := builder.AddRelation("A", 10)
+		b := builder.AddRelation("B", 1000)
+		c := builder.AddRelation("C", 1000)
+
+		builder.AddPredicate(a, b, 0.001)
+		builder.AddPredicate(b, c, 0.001)
+		// A ⟕ (B ⋈ C) can't start by joining A and B.
+		if tc.outer {
+			builder.AddOuterJoin(schema.LeftOuterJoin, schema.S(a), schema.S(b, c))
+		}
+
+		s := builder.MustBuild()
+		if j := NewDPSizeOrderer(s).Order(); j.String() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, j)
+		}
+		if j := NewParetoOrderer(s).Order(); tc.outer && j.String() != tc.expected {
+			t.Errorf("pareto: expected %q, got %q", tc.expected, j)
+		}
+	}
+}
+
+func TestDPSizeOrdererDistributedOuterJoins(t *testing.T) {
+	for _, tc := range []struct {
+		kind     schema.JoinKind
+		flip     bool
+		expected string
+	}{
+		// A is replicated, so it can be joined to B where B is, as long as
+		// only the rows of B are preserved.
+		{schema.InnerJoin, false, "gather((A ⋈ B))"},
+		{schema.LeftOuterJoin, true, "gather((A ⟖ B))"},
+		// But no node can tell which rows of A match no row of B anywhere, or
+		// whether some other node has produced a row of A already.
+		{schema.LeftOuterJoin, false, "gather((shuffle(A) ⟕ B))"},
+		{schema.FullOuterJoin, false, "gather((shuffle(A) ⟗ B))"},
+		{schema.SemiJoin, false, "gather((shuffle(A) ⋉ B))"},
+		{schema.AntiJoin, false, "gather((shuffle(A) ▷ B))"},
+	} {
+		builder := schema.NewBuilder()
+
+		a := builder.AddRelation("A", 100)
+		b := builder.AddRelation("B", 1000000)
+		k := builder.AddPredicate(a, b, 0.01)
+		builder.SetDistribution(a, schema.Distribution{Kind: schema.Replicated})
+		builder.SetDistribution(b, schema.HashedOn(k))
+
+		l, r := schema.S(a), schema.S(b)
+		if tc.flip {
+			l, r = r, l
+		}
+		switch tc.kind {
+		case schema.SemiJoin:
+			builder.AddSemiJoin(l, r)
+		case schema.AntiJoin:
+			builder.AddAntiJoin(l, r)
+		case schema.InnerJoin:
+		default:
+			builder.AddOuterJoin(tc.kind, l, r)
+		}
+
+		o := NewDPSizeOrderer(builder.MustBuild())
+		o.SetCostModel(cost.Distributed{Nodes: 10, Network: 1})
+		if j := o.Order(); j.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.kind, tc.expected, j)
+		}
+	}
+}
+
+func TestDPSizeOrdererSemiJoins(t *testing.T) {
+	for _, tc := range []struct {
+		distinct schema.Cardinality
+		expected string
+	}{
+		// B is gathered on the node holding A.
+		{10000, "(A ⋉ gather(B))"},
+		// B has few enough distinct values that removing its duplicates before
+		// gathering them is cheaper.
+		{10, "(A ⋈ gather(δ(B)))"},
+	} {
+		builder := schema.NewBuilder()
+
+		a := builder.AddRelation("A", 1000000)
+		b := builder.AddRelation("B", 10000)
+
+		k := builder.AddPredicate(a, b, schema.Selectivity(1/float64(tc.distinct)))
+		builder.AddSemiJoin(schema.S(a), schema.S(b))
+		// A is on a single node, and B is partitioned on the key of the
+		// semi join, so each node can remove its duplicates on its own.
+		builder.SetDistribution(b, schema.HashedOn(k))
+
+		o := NewDPSizeOrderer(builder.MustBuild())
+		o.SetCostModel(cost.Distributed{Nodes: 4, Network: 10})
+		if j := o.Order(); j.String() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, j)
+		}
+	}
+}
+
+func TestDPSizeOrdererGroupBy(t *testing.T) {
+	for _, tc := range []struct {
+		distinct float64
+		expected string
+	}{
+		// Aggregating A on g and the key it is joined on leaves 10000 groups,
+		// which is cheaper to join than all of A.
+		{10, "γ((γ(A) ⋈ B))"},
+		// Every row of A is in its own group, so aggregating it early saves
+		// nothing.
+		{1000000, "γ((A ⋈ B))"},
+	} {
+		builder := schema.NewBuilder()
+
+		a := builder.AddRelation("A", 1000000)
+		b := builder.AddRelation("B", 1000)
+		g := builder.AddColumn(a, "g")
+		builder.SetColumnStats(g, schema.ColumnStats{DistinctCount: tc.distinct})
+		builder.AddPredicate(a, b, 0.001)
+		builder.SetGroupBy(g)
+
+		o := NewDPSizeOrderer(builder.MustBuild())
+		if j := o.Order(); j.String() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, j)
+		}
+	}
+}
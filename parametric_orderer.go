@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// ParametricOrderer finds the plans which are optimal for some binding of the
+// Parameters of a schema, along with the region of the parameter space each
+// one covers. It approximates the parameter space by a grid, whose points are
+// spaced evenly on a log scale between the bounds of each Parameter, and
+// optimizes the query at each point with a DPSizeOrderer.
+type ParametricOrderer struct {
+	s     *schema.Schema
+	steps int
+	plans []ParametricPlan
+}
+
+// ParametricPlan is a plan along with the region of the parameter space in
+// which it is optimal.
+type ParametricPlan struct {
+	Join join.Join
+	// Points are the points of the grid at which the plan is optimal. Each
+	// point gives the selectivity of every Parameter of the schema.
+	Points [][]schema.Selectivity
+}
+
+// Bounds returns the smallest box containing every point at which p is
+// optimal. The region p covers need not fill the box.
+func (p ParametricPlan) Bounds() (lo, hi []schema.Selectivity) {
+	lo = append([]schema.Selectivity(nil), p.Points[0]...)
+	hi = append([]schema.Selectivity(nil), p.Points[0]...)
+	for _, pt := range p.Points[1:] {
+		for i, v := range pt {
+			if v < lo[i] {
+				lo[i] = v
+			}
+			if v > hi[i] {
+				hi[i] = v
+			}
+		}
+	}
+	return lo, hi
+}
+
+func NewParametricOrderer(s *schema.Schema) *ParametricOrderer {
+	return &ParametricOrderer{
+		s:     s,
+		steps: 5,
+	}
+}
+
+// SetSteps sets the number of points along each dimension of the grid. The
+// default is 5.
+func (o *ParametricOrderer) SetSteps(n int) {
+	if n < 2 {
+		panic("grid must have at least two steps")
+	}
+	o.steps = n
+	o.plans = nil
+}
+
+// Order returns the plan which is optimal at the most points of the grid.
+func (o *ParametricOrderer) Order() join.Join {
+	plans := o.Plans()
+	best := plans[0]
+	for _, p := range plans[1:] {
+		if len(p.Points) > len(best.Points) {
+			best = p
+		}
+	}
+	return best.Join
+}
+
+// Plans returns every plan which is optimal at some point of the grid, in the
+// order they were found.
+func (o *ParametricOrderer) Plans() []ParametricPlan {
+	if o.plans != nil {
+		return o.plans
+	}
+
+	params := o.s.Parameters()
+	step := make([]int, len(params))
+	for {
+		pt := make([]schema.Selectivity, len(params))
+		for i, p := range params {
+			pt[i] = o.value(p, step[i])
+		}
+		o.add(NewDPSizeOrderer(o.s.Bind(pt)).Order(), pt)
+
+		// Advance to the next point of the grid.
+		i := 0
+		for ; i < len(step); i++ {
+			step[i]++
+			if step[i] < o.steps {
+				break
+			}
+			step[i] = 0
+		}
+		if i == len(step) {
+			break
+		}
+	}
+	return o.plans
+}
+
+// value returns the selectivity of p at the given step along its dimension of
+// the grid.
+func (o *ParametricOrderer) value(p schema.Parameter, step int) schema.Selectivity {
+	lo, hi := float64(p.Lo), float64(p.Hi)
+	return schema.Selectivity(lo * math.Pow(hi/lo, float64(step)/float64(o.steps-1)))
+}
+
+func (o *ParametricOrderer) add(j join.Join, pt []schema.Selectivity) {
+	for i := range o.plans {
+		if o.plans[i].Join.String() == j.String() {
+			o.plans[i].Points = append(o.plans[i].Points, pt)
+			return
+		}
+	}
+	o.plans = append(o.plans, ParametricPlan{
+		Join:   j,
+		Points: [][]schema.Selectivity{pt},
+	})
+}
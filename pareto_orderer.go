@@ -0,0 +1,283 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/justinj/joinorder/cost"
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+	"github.com/justinj/joinorder/util"
+)
+
+// ParetoOrderer is a DPsize orderer which, rather than finding the single
+// cheapest plan, finds every plan which is not dominated by another along all
+// of the objectives of a cost.MultiModel.
+type ParetoOrderer struct {
+	s     *schema.Schema
+	j     *join.Forest
+	m     cost.MultiModel
+	costs map[join.ExprID]cost.Vector
+	cards map[join.ExprID]schema.Cardinality
+
+	// sets[k] holds every set of k relations that has a plan. The group for
+	// each set holds its Pareto frontier of plans.
+	sets [][]schema.RelSet
+	// result is the frontier of finished plans for the whole query, once it
+	// has been found.
+	result []ParetoPlan
+}
+
+// ParetoPlan is a plan on the Pareto frontier, along with its cost.
+type ParetoPlan struct {
+	Join join.Join
+	Cost cost.Vector
+}
+
+func NewParetoOrderer(s *schema.Schema) *ParetoOrderer {
+	return &ParetoOrderer{
+		s:     s,
+		j:     join.NewForest(s),
+		m:     cost.Resources{Model: cost.Local{}},
+		costs: make(map[join.ExprID]cost.Vector),
+		cards: make(map[join.ExprID]schema.Cardinality),
+		sets:  make([][]schema.RelSet, s.NumRels()+1),
+	}
+}
+
+// SetCostModel sets the MultiModel used to cost plans. The default is
+// cost.Resources over cost.Local.
+func (o *ParetoOrderer) SetCostModel(m cost.MultiModel) {
+	o.m = m
+}
+
+// Order returns the plan on the frontier which does the least work.
+func (o *ParetoOrderer) Order() join.Join {
+	frontier := o.Frontier()
+	best := frontier[0]
+	for _, p := range frontier[1:] {
+		if p.Cost[cost.Work] < best.Cost[cost.Work] {
+			best = p
+		}
+	}
+	return best.Join
+}
+
+// Frontier returns the Pareto frontier of plans for the whole query, in the
+// order they were found. Each is finished as described by finish, which can
+// leave some of them dominated by others.
+func (o *ParetoOrderer) Frontier() []ParetoPlan {
+	if o.result != nil {
+		return o.result
+	}
+	o.enumerate()
+
+	all := util.MakeFastIntSet()
+	all.AddRange(1, o.s.NumRels())
+
+	// Finishing the plans adds to the group they are in.
+	for _, g := range append([]join.ExprID(nil), o.frontier(all)...) {
+		g = o.finish(g)
+		p := ParetoPlan{Join: o.j.AsJoin(g), Cost: o.costs[g]}
+		dominated := false
+		kept := o.result[:0]
+		for _, q := range o.result {
+			dominated = dominated || q.Cost.Dominates(p.Cost)
+			if !p.Cost.Dominates(q.Cost) {
+				kept = append(kept, q)
+			}
+		}
+		if !dominated {
+			o.result = append(kept, p)
+		}
+	}
+	return o.result
+}
+
+// finish finishes g, a plan for the whole query, and records the cost of each
+// operator added above it. None of them are plans on a frontier of their own.
+func (o *ParetoOrderer) finish(g join.ExprID) join.ExprID {
+	f := finish(o.j, g)
+	o.costAbove(f, g)
+	return f
+}
+
+// costAbove records the cost and cardinality of e and of each operator
+// between it and g, which it is reached from through the left input of each.
+func (o *ParetoOrderer) costAbove(e, g join.ExprID) {
+	if e == g {
+		return
+	}
+	in, _ := o.j.Children(e)
+	o.costAbove(in, g)
+	props := o.props(in, o.cards[in])
+	o.cards[e] = o.cards[in]
+	switch o.j.Operator(e) {
+	case join.Exchange:
+		o.costs[e] = o.m.Exchange(props, o.j.Physical(e).Distribution, o.costs[in])
+	case join.GroupBy:
+		o.costs[e] = o.m.GroupBy(props, o.costs[in])
+		o.cards[e] = o.s.GroupCardinality(props.Relations, o.cards[in])
+	case join.Sort:
+		o.costs[e] = o.m.Sort(props, o.costs[in])
+	}
+	o.j.Remove(e)
+}
+
+func (o *ParetoOrderer) enumerate() {
+	for i := 1; i <= o.s.NumRels(); i++ {
+		r := schema.RelationID(i)
+		l := o.j.AddLeaf(r)
+		o.add(l, o.s.Cardinality(r), o.m.Scan(o.props(l, o.s.Cardinality(r))))
+	}
+
+	for s := 2; s <= o.s.NumRels(); s++ {
+		for s1 := 1; s1 < s; s1++ {
+			s2 := s - s1
+			for _, lMembers := range o.sets[s1] {
+				for _, rMembers := range o.sets[s2] {
+					if lMembers.Intersects(rMembers) {
+						continue
+					}
+
+					if !o.s.SubgraphsAdjacent(lMembers, rMembers) || !o.s.CanJoin(lMembers, rMembers) {
+						continue
+					}
+
+					o.join(lMembers, rMembers)
+				}
+			}
+		}
+	}
+	o.crossComponents()
+}
+
+// crossComponents combines the frontiers for each connected component of the
+// query graph with cross products, in increasing order of the cardinality of
+// the components.
+func (o *ParetoOrderer) crossComponents() {
+	components := o.s.Components()
+	if len(components) < 2 {
+		return
+	}
+	card := func(set schema.RelSet) schema.Cardinality {
+		return o.cards[o.frontier(set)[0]]
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		return card(components[i]) < card(components[j])
+	})
+
+	acc := components[0]
+	for _, c := range components[1:] {
+		o.join(acc, c)
+		o.join(c, acc)
+		acc = acc.Union(c)
+	}
+}
+
+// frontier returns the plans on the Pareto frontier for set.
+func (o *ParetoOrderer) frontier(set schema.RelSet) []join.ExprID {
+	g, ok := o.j.Group(set, join.Logical{})
+	if !ok {
+		return nil
+	}
+	return o.j.Exprs(g)
+}
+
+func (o *ParetoOrderer) props(g join.ExprID, card schema.Cardinality) cost.Props {
+	return cost.Props{
+		Relations: o.j.GetMembers(g),
+		Card:      card,
+		Physical:  o.j.Physical(g),
+	}
+}
+
+// join considers hash joins of every pair of plans on the frontiers of l and
+// r, with the rows of either moved between nodes first in each way which lets
+// the join be performed in place.
+func (o *ParetoOrderer) join(lMembers, rMembers schema.RelSet) {
+	lFrontier := o.frontier(lMembers)
+	rFrontier := o.frontier(rMembers)
+
+	for _, l := range lFrontier {
+		for _, r := range rFrontier {
+			newCard := o.s.JoinCardinality(lMembers, rMembers, o.cards[l], o.cards[r])
+			dl, dr := o.j.Physical(l).Distribution, o.j.Physical(r).Distribution
+			for _, d := range joinInputs(o.s, lMembers, rMembers, dl, dr) {
+				lProps, lCost := o.exchanged(l, d[0])
+				rProps, rCost := o.exchanged(r, d[1])
+				out, _ := o.s.JoinDistribution(lMembers, rMembers, d[0], d[1])
+				c := o.m.Join(
+					join.HashJoin,
+					lProps,
+					rProps,
+					cost.Props{
+						Relations: lMembers.Union(rMembers),
+						Card:      newCard,
+						Physical:  join.Physical{Distribution: out},
+					},
+					lCost,
+					rCost,
+				)
+				if o.dominated(lMembers.Union(rMembers), c) {
+					continue
+				}
+				o.add(o.j.AddJoin(o.exchange(l, d[0]), o.exchange(r, d[1])), newCard, c)
+			}
+		}
+	}
+}
+
+// exchanged returns the properties and cost of the rows of g once they are
+// distributed as d.
+func (o *ParetoOrderer) exchanged(g join.ExprID, d schema.Distribution) (cost.Props, cost.Vector) {
+	props := o.props(g, o.cards[g])
+	if props.Physical.Distribution == d {
+		return props, o.costs[g]
+	}
+	c := o.m.Exchange(props, d, o.costs[g])
+	props.Physical = join.Physical{Distribution: d}
+	return props, c
+}
+
+// exchange returns g with its rows distributed as d, adding an exchange if
+// they aren't already. The exchange isn't a plan on the frontier of its set of
+// relations in its own right; it is only considered as the input of a join.
+func (o *ParetoOrderer) exchange(g join.ExprID, d schema.Distribution) join.ExprID {
+	next := exchange(o.j, g, d)
+	if next != g {
+		_, o.costs[next] = o.exchanged(g, d)
+		o.cards[next] = o.cards[g]
+		o.j.Remove(next)
+	}
+	return next
+}
+
+// dominated returns whether a plan for set with cost c would be dominated by
+// a plan already on its frontier.
+func (o *ParetoOrderer) dominated(set schema.RelSet, c cost.Vector) bool {
+	for _, g := range o.frontier(set) {
+		if o.costs[g].Dominates(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds g to the frontier for its set of relations, removing any plans it
+// dominates.
+func (o *ParetoOrderer) add(g join.ExprID, card schema.Cardinality, c cost.Vector) {
+	o.cards[g] = card
+	o.costs[g] = c
+
+	set := o.j.GetMembers(g)
+	frontier := o.frontier(set)
+	if len(frontier) == 1 {
+		o.sets[set.Len()] = append(o.sets[set.Len()], set)
+	}
+
+	for _, old := range frontier {
+		if old != g && c.Dominates(o.costs[old]) {
+			o.j.Remove(old)
+		}
+	}
+}
@@ -25,5 +25,5 @@ func Bushy() *schema.Schema {
 	builder.AddPredicate(b, c, 0.5)
 	builder.AddPredicate(c, d, 0.01)
 
-	return builder.Build()
+	return builder.MustBuild()
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/justinj/joinorder/cost"
+	"github.com/justinj/joinorder/join"
+	"github.com/justinj/joinorder/schema"
+)
+
+// RobustOrderer chooses a plan which performs well across the range of
+// cardinalities and selectivities allowed by the error bounds of a schema,
+// rather than one which is optimal for its estimates. It draws samples of the
+// schema, takes the optimal plan for each sample (and for the estimates) as
+// candidates, and costs every candidate against every sample.
+type RobustOrderer struct {
+	s         *schema.Schema
+	m         cost.Model
+	samples   int
+	seed      int64
+	worstCase bool
+	report    []RobustPlan
+}
+
+// RobustPlan is a candidate plan along with statistics of its cost across the
+// samples.
+type RobustPlan struct {
+	Join     join.Join
+	Mean     float64
+	Variance float64
+	Max      float64
+}
+
+func NewRobustOrderer(s *schema.Schema) *RobustOrderer {
+	return &RobustOrderer{
+		s:       s,
+		m:       cost.Local{},
+		samples: 100,
+		seed:    1,
+	}
+}
+
+// SetCostModel sets the Model used to cost plans. The default is cost.Local.
+func (o *RobustOrderer) SetCostModel(m cost.Model) {
+	o.m = m
+	o.report = nil
+}
+
+// SetSamples sets the number of samples to draw. The default is 100.
+func (o *RobustOrderer) SetSamples(n int) {
+	o.samples = n
+	o.report = nil
+}
+
+// SetSeed sets the seed samples are drawn with. The default is 1.
+func (o *RobustOrderer) SetSeed(seed int64) {
+	o.seed = seed
+	o.report = nil
+}
+
+// SetWorstCase chooses whether to minimize the worst case cost of a plan
+// rather than its expected cost. The default is the expected cost.
+func (o *RobustOrderer) SetWorstCase(worstCase bool) {
+	o.worstCase = worstCase
+	o.report = nil
+}
+
+// Order returns the candidate with the lowest expected or worst case cost.
+func (o *RobustOrderer) Order() join.Join {
+	return o.Report()[0].Join
+}
+
+// Report returns every candidate plan, from best to worst.
+func (o *RobustOrderer) Report() []RobustPlan {
+	if o.report != nil {
+		return o.report
+	}
+
+	rng := rand.New(rand.NewSource(o.seed))
+	samples := make([]*schema.Schema, o.samples)
+	for i := range samples {
+		samples[i] = o.s.Sample(rng)
+	}
+
+	var candidates []join.Join
+	for _, s := range append([]*schema.Schema{o.s}, samples...) {
+		d := NewDPSizeOrderer(s)
+		d.SetCostModel(o.m)
+		j := d.Order()
+
+		found := false
+		for _, c := range candidates {
+			if c.String() == j.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			candidates = append(candidates, j)
+		}
+	}
+
+	for _, c := range candidates {
+		p := RobustPlan{Join: c}
+		costs := make([]float64, len(samples))
+		for i, s := range samples {
+			costs[i] = cost.AnnotateWith(c, s, o.m).Root().Total
+			p.Mean += costs[i] / float64(len(samples))
+			p.Max = math.Max(p.Max, costs[i])
+		}
+		for _, c := range costs {
+			p.Variance += (c - p.Mean) * (c - p.Mean) / float64(len(samples))
+		}
+		o.report = append(o.report, p)
+	}
+
+	sort.SliceStable(o.report, func(i, j int) bool {
+		if o.worstCase {
+			return o.report[i].Max < o.report[j].Max
+		}
+		return o.report[i].Mean < o.report[j].Mean
+	})
+	return o.report
+}
@@ -0,0 +1,377 @@
+package schema
+
+import (
+	"math"
+	"sort"
+)
+
+type ColumnName string
+type ColumnID int
+
+// EqualityID identifies an equality added with AddEquality.
+type EqualityID int
+
+type Column struct {
+	Name     ColumnName
+	Relation RelationID
+	// key is the JoinKey of the values of the column. Equal columns end up
+	// sharing a key once the schema is built.
+	key JoinKey
+
+	stats    ColumnStats
+	hasStats bool
+	hist     *Histogram
+}
+
+// equality is a predicate which compares two columns for equality. Its
+// selectivity is 0 if it is to be estimated from the statistics of the
+// columns. Equalities which are only implied by others have no id.
+type equality struct {
+	id   EqualityID
+	x, y ColumnID
+	sel  Selectivity
+	// fromStats is whether sel was estimated from the statistics of the
+	// columns, so that it depends on how many distinct values they have left
+	// once their relations have been joined to others.
+	fromStats bool
+}
+
+// class is an equivalence class of columns which are all equal to each other,
+// either directly through an equality or transitively.
+type class struct {
+	key JoinKey
+	// rels is the set of relations with a column in the class, and cols maps
+	// each of them to its first column in the class.
+	rels RelSet
+	cols map[RelationID]ColumnID
+	// edges are the equalities between columns of the class, least selective
+	// first. Besides the equalities which were added explicitly, there is one
+	// for each pair of columns with statistics which are only implied to be
+	// equal, with a selectivity estimated from them.
+	edges []equality
+	// implied is the selectivity assumed for an equality between two columns
+	// of the class which is only implied by other equalities and can't be
+	// estimated. It is the geometric mean of the selectivities of the edges.
+	implied Selectivity
+}
+
+// AddColumn adds a column named name to r.
+func (b *Builder) AddColumn(r RelationID, name ColumnName) ColumnID {
+	if !b.validRelation(r) {
+		return 0
+	}
+	for _, c := range b.columns {
+		if c.Relation == r && c.Name == name {
+			b.problemf(DuplicateName, "duplicate column name %s.%s", b.relation(r).Name, name)
+			return 0
+		}
+	}
+	b.columns = append(b.columns, Column{
+		Name:     name,
+		Relation: r,
+		key:      b.NewJoinKey(),
+	})
+	return ColumnID(len(b.columns))
+}
+
+func (b *Builder) column(c ColumnID) Column {
+	if int(c)-1 >= len(b.columns) || c < 1 {
+		panic("invalid ColumnID")
+	}
+	return b.columns[c-1]
+}
+
+// ColumnKey returns the JoinKey of the values of c, which can be used to sort
+// or partition on c.
+func (b *Builder) ColumnKey(c ColumnID) JoinKey {
+	if !b.validColumn(c) {
+		return 0
+	}
+	return b.column(c).key
+}
+
+// AddEquality adds a join predicate x = y between columns of two different
+// relations. Equalities are transitive, so if x = y and y = z then the
+// relations of x and z can also be joined on x = z.
+func (b *Builder) AddEquality(x, y ColumnID, sel Selectivity) EqualityID {
+	if !b.validColumn(x) || !b.validColumn(y) || sel != 0 && !b.validSelectivity(sel) {
+		return 0
+	}
+	if b.column(x).Relation == b.column(y).Relation {
+		b.problemf(SelfJoin, "can't equate columns %d and %d of the same relation", x, y)
+		return 0
+	}
+	id := EqualityID(len(b.equalities) + 1)
+	b.equalities = append(b.equalities, equality{id: id, x: x, y: y, sel: sel})
+	return id
+}
+
+// validEquality returns whether e exists, recording a problem if not.
+func (b *Builder) validEquality(e EqualityID) bool {
+	if int(e)-1 >= len(b.equalities) || e < 1 {
+		b.problemf(UnknownPredicate, "no equality with id %d", e)
+		return false
+	}
+	return true
+}
+
+func (b *Builder) equality(e EqualityID) equality {
+	return b.equalities[e-1]
+}
+
+// buildClasses computes the equivalence classes of the columns, and returns
+// them along with a map from the key of each column in a class to the key of
+// its class.
+func (b *Builder) buildClasses(problems *problemList) ([]class, map[JoinKey]JoinKey) {
+	parent := make([]ColumnID, len(b.columns)+1)
+	for i := range parent {
+		parent[i] = ColumnID(i)
+	}
+	var find func(c ColumnID) ColumnID
+	find = func(c ColumnID) ColumnID {
+		if parent[c] != c {
+			parent[c] = find(parent[c])
+		}
+		return parent[c]
+	}
+	for _, e := range b.equalities {
+		x, y := find(e.x), find(e.y)
+		if x > y {
+			x, y = y, x
+		}
+		parent[y] = x
+	}
+
+	var classes []class
+	classIdx := make(map[ColumnID]int)
+	canonical := make(map[JoinKey]JoinKey)
+	for _, e := range b.equalities {
+		root := find(e.x)
+		idx, ok := classIdx[root]
+		if !ok {
+			idx = len(classes)
+			classIdx[root] = idx
+			classes = append(classes, class{key: b.column(root).key})
+		}
+		c := &classes[idx]
+		for _, col := range []ColumnID{e.x, e.y} {
+			r := b.column(col).Relation
+			if !c.rels.Contains(int(r)) {
+				c.rels.Add(int(r))
+				if c.cols == nil {
+					c.cols = make(map[RelationID]ColumnID)
+				}
+				c.cols[r] = col
+			}
+		}
+		if e.sel == 0 || b.column(e.x).hist != nil && b.column(e.y).hist != nil {
+			est, ok := b.estimate(e.x, e.y)
+			if !ok {
+				problems.addf(InvalidEstimate, "can't estimate equality of columns %d and %d without statistics", e.x, e.y)
+				est.sel = 1
+			}
+			e.sel, e.fromStats = est.sel, est.fromStats
+		}
+		c.edges = append(c.edges, e)
+	}
+
+	for i := range b.columns {
+		if idx, ok := classIdx[find(ColumnID(i+1))]; ok {
+			canonical[b.columns[i].key] = classes[idx].key
+		}
+	}
+
+	for i := range classes {
+		c := &classes[i]
+		c.addEstimatedEdges(b)
+		sort.SliceStable(c.edges, func(i, j int) bool {
+			return c.edges[i].sel > c.edges[j].sel
+		})
+		logSum := 0.0
+		for _, e := range c.edges {
+			logSum += math.Log(float64(e.sel))
+		}
+		c.implied = Selectivity(math.Exp(logSum / float64(len(c.edges))))
+	}
+
+	return classes, canonical
+}
+
+// addEstimatedEdges adds an edge with an estimated selectivity for each pair
+// of relations of c which have statistics for their column in c and aren't
+// already joined by an explicit equality.
+func (c *class) addEstimatedEdges(b *Builder) {
+	explicit := make(map[int]bool)
+	for _, e := range c.edges {
+		explicit[pair(b.column(e.x).Relation, b.column(e.y).Relation)] = true
+	}
+	for i, ok := c.rels.Next(0); ok; i, ok = c.rels.Next(i + 1) {
+		for j, ok := c.rels.Next(i + 1); ok; j, ok = c.rels.Next(j + 1) {
+			if explicit[pair(RelationID(i), RelationID(j))] {
+				continue
+			}
+			x, y := c.cols[RelationID(i)], c.cols[RelationID(j)]
+			if e, ok := b.estimate(x, y); ok {
+				c.edges = append(c.edges, e)
+			}
+		}
+	}
+}
+
+// estimate returns the equality x = y with its selectivity among their
+// non-null values estimated: from a foreign key between them if there is one,
+// then from their histograms if they both have one, and otherwise from their
+// statistics. It returns false if there is nothing to estimate it from.
+//
+// However little the values of x and y seem to overlap, at least one pair of
+// rows of their relations is estimated to match, since a plan above a join
+// estimated to produce no rows would look free.
+func (b *Builder) estimate(x, y ColumnID) (equality, bool) {
+	e := equality{x: x, y: y}
+	if sel, ok := b.estimateForeignKey(x, y); ok {
+		e.sel = sel
+		return e, true
+	}
+	cx, cy := b.column(x), b.column(y)
+	switch {
+	case cx.hist != nil && cy.hist != nil:
+		e.sel = estimateHistogramEquality(*cx.hist, *cy.hist)
+	case cx.hasStats && cy.hasStats:
+		e.sel, e.fromStats = estimateEquality(cx.stats, cy.stats), true
+	default:
+		return e, false
+	}
+	if pairs := float64(b.relation(cx.Relation).card) * float64(b.relation(cy.Relation).card); pairs > 0 {
+		e.sel = Selectivity(math.Max(float64(e.sel), 1/pairs))
+	}
+	return e, true
+}
+
+// fromStats returns whether every relation of c in set has statistics for its
+// column, and every equality of c between them was estimated from those.
+func (c *class) fromStats(s *Schema, set RelSet) bool {
+	members := c.rels.Intersection(set)
+	for i, ok := members.Next(0); ok; i, ok = members.Next(i + 1) {
+		if _, ok := s.ColumnStats(c.cols[RelationID(i)]); !ok {
+			return false
+		}
+	}
+	for _, e := range c.edges {
+		if members.Contains(int(s.Column(e.x).Relation)) && members.Contains(int(s.Column(e.y).Relation)) && !e.fromStats {
+			return false
+		}
+	}
+	return true
+}
+
+// selectivity returns the combined selectivity of the equalities of c between
+// the relations of s. Since the equalities are transitive only enough of them
+// to connect the relations are counted: the least selective edges, with
+// implied ones making up the difference. For edges estimated from distinct
+// counts this gives the product of 1/ndv over every column except the one
+// with the fewest distinct values. Null values never compare equal, so the
+// rows with a null in any of the columns are removed as well.
+func (c *class) selectivity(s *Schema, set RelSet) Selectivity {
+	preds, nulls := c.factors(s, set)
+	var sel Selectivity = 1
+	for _, p := range preds {
+		sel *= p
+	}
+	for _, n := range nulls {
+		sel *= n
+	}
+	return sel
+}
+
+// factors returns the selectivities of the equalities making up the
+// selectivity of c over set, and the fractions of non-null rows of its
+// columns. An equality with a joint selectivity is accounted for by the joint
+// selectivity, so its relations are connected first and it contributes
+// nothing.
+func (c *class) factors(s *Schema, set RelSet) (preds, nulls []Selectivity) {
+	members := c.rels.Intersection(set)
+	if members.Len() < 2 {
+		return nil, nil
+	}
+
+	parent := make(map[RelationID]RelationID)
+	var find func(r RelationID) RelationID
+	find = func(r RelationID) RelationID {
+		if p, ok := parent[r]; ok && p != r {
+			parent[r] = find(p)
+			return parent[r]
+		}
+		return r
+	}
+
+	components := members.Len()
+	connect := func(e equality) bool {
+		x, y := s.Column(e.x).Relation, s.Column(e.y).Relation
+		if !members.Contains(int(x)) || !members.Contains(int(y)) {
+			return false
+		}
+		if x, y := find(x), find(y); x != y {
+			parent[y] = x
+			components--
+			return true
+		}
+		return false
+	}
+	for _, e := range c.edges {
+		if _, ok := s.jointFor(e); ok {
+			connect(e)
+		}
+	}
+	for _, e := range c.edges {
+		if connect(e) {
+			preds = append(preds, e.sel)
+		}
+	}
+	for ; components > 1; components-- {
+		preds = append(preds, c.implied)
+	}
+	for i, ok := members.Next(0); ok; i, ok = members.Next(i + 1) {
+		if stats, ok := s.ColumnStats(c.cols[RelationID(i)]); ok {
+			nulls = append(nulls, Selectivity(1-stats.NullFraction))
+		}
+	}
+	return preds, nulls
+}
+
+// Column returns the column with the given ID.
+func (s *Schema) Column(c ColumnID) Column {
+	if int(c)-1 >= len(s.columns) || c < 1 {
+		panic("invalid ColumnID")
+	}
+	return s.columns[c-1]
+}
+
+func (s *Schema) NumColumns() int {
+	return len(s.columns)
+}
+
+// ColumnKey returns the JoinKey of the values of c, which is shared by every
+// column equal to it.
+func (s *Schema) ColumnKey(c ColumnID) JoinKey {
+	return s.canonicalKey(s.Column(c).key)
+}
+
+// EquivalentColumns returns every column known to be equal to c, including c
+// itself.
+func (s *Schema) EquivalentColumns(c ColumnID) []ColumnID {
+	k := s.ColumnKey(c)
+	var result []ColumnID
+	for i := range s.columns {
+		if s.ColumnKey(ColumnID(i+1)) == k {
+			result = append(result, ColumnID(i+1))
+		}
+	}
+	return result
+}
+
+func (s *Schema) canonicalKey(k JoinKey) JoinKey {
+	if c, ok := s.canonical[k]; ok {
+		return c
+	}
+	return k
+}
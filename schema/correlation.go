@@ -0,0 +1,164 @@
+package schema
+
+import (
+	"math"
+	"sort"
+)
+
+// Combiner combines the selectivities of the predicates applied to the same
+// set of relations into the selectivity of their conjunction.
+type Combiner interface {
+	Combine(sels []Selectivity) Selectivity
+}
+
+// Independence assumes the predicates are independent, so their selectivity
+// is the product of theirs. It is the default.
+type Independence struct{}
+
+func (Independence) Combine(sels []Selectivity) Selectivity {
+	var sel Selectivity = 1
+	for _, s := range sels {
+		sel *= s
+	}
+	return sel
+}
+
+// ExponentialBackoff assumes the predicates are correlated, and dampens each
+// predicate after the most selective one by a further square root:
+// s1 * s2^(1/2) * s3^(1/4) * s4^(1/8). Only the four most selective
+// predicates are counted.
+type ExponentialBackoff struct{}
+
+func (ExponentialBackoff) Combine(sels []Selectivity) Selectivity {
+	sorted := append([]Selectivity(nil), sels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	if len(sorted) > 4 {
+		sorted = sorted[:4]
+	}
+	sel, exp := 1.0, 1.0
+	for _, s := range sorted {
+		sel *= math.Pow(float64(s), exp)
+		exp /= 2
+	}
+	return Selectivity(sel)
+}
+
+// Minimum assumes the predicates are fully correlated, so only the most
+// selective one has any effect.
+type Minimum struct{}
+
+func (Minimum) Combine(sels []Selectivity) Selectivity {
+	var sel Selectivity = 1
+	for _, s := range sels {
+		if s < sel {
+			sel = s
+		}
+	}
+	return sel
+}
+
+// SetCombiner sets how the selectivities of the predicates of a query are
+// combined.
+func (b *Builder) SetCombiner(c Combiner) {
+	b.combiner = c
+}
+
+// joint is a group of equalities between the same two relations whose
+// combined selectivity is known.
+type joint struct {
+	x, y    RelationID
+	members []EqualityID
+	sel     Selectivity
+}
+
+// SetJointSelectivity declares the selectivity of the conjunction of eqs,
+// which must all be between the same two relations. It is counted as a
+// single predicate in place of the individual equalities, so for instance
+// a.x = b.x AND a.y = b.y can be given a selectivity which accounts for x and
+// y being correlated.
+func (b *Builder) SetJointSelectivity(sel Selectivity, eqs ...EqualityID) {
+	if len(eqs) == 0 {
+		b.problemf(InvalidConstraint, "no equalities in joint selectivity")
+		return
+	}
+	if !b.validSelectivity(sel) {
+		return
+	}
+	j := joint{sel: sel}
+	for _, id := range eqs {
+		if !b.validEquality(id) {
+			return
+		}
+		e := b.equality(id)
+		x, y := b.column(e.x).Relation, b.column(e.y).Relation
+		if j.x == 0 {
+			j.x, j.y = x, y
+		}
+		if pair(x, y) != pair(j.x, j.y) {
+			b.problemf(InvalidConstraint, "equalities %v aren't all between the same relations", eqs)
+			return
+		}
+		for _, other := range b.joints {
+			for _, m := range other.members {
+				if m == id {
+					b.problemf(InvalidConstraint, "equality %d already has a joint selectivity", id)
+					return
+				}
+			}
+		}
+		j.members = append(j.members, id)
+	}
+	b.joints = append(b.joints, j)
+}
+
+// correlated returns whether the selectivities of s can't simply be
+// multiplied together.
+func (s *Schema) correlated() bool {
+	return s.combiner != nil || len(s.joints) > 0
+}
+
+// jointFor returns the joint selectivity e belongs to, if any.
+func (s *Schema) jointFor(e equality) (*joint, bool) {
+	if e.id == 0 {
+		return nil, false
+	}
+	for i := range s.joints {
+		for _, m := range s.joints[i].members {
+			if m == e.id {
+				return &s.joints[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// conjunctionSelectivity returns the selectivity of every predicate between
+// the relations of set, combined with the Combiner of s.
+func (s *Schema) conjunctionSelectivity(set RelSet) Selectivity {
+	var sels []Selectivity
+	for _, p := range s.predicates {
+		if set.Contains(int(p.X)) && set.Contains(int(p.Y)) {
+			sels = append(sels, p.Selectivity)
+		}
+	}
+	for _, j := range s.joints {
+		if set.Contains(int(j.x)) && set.Contains(int(j.y)) {
+			sels = append(sels, j.sel)
+		}
+	}
+
+	var nulls Selectivity = 1
+	for i := range s.classes {
+		preds, classNulls := s.classes[i].factors(s, set)
+		sels = append(sels, preds...)
+		for _, n := range classNulls {
+			nulls *= n
+		}
+	}
+
+	c := s.combiner
+	if c == nil {
+		c = Independence{}
+	}
+	return c.Combine(sels) * nulls
+}
@@ -0,0 +1,105 @@
+package schema
+
+import "fmt"
+
+// DistributionKind is the way the rows of a relation are spread across the
+// nodes of a cluster.
+type DistributionKind int
+
+const (
+	// Singleton rows all live on a single node.
+	Singleton DistributionKind = iota
+	// Hashed rows are partitioned across nodes by the hash of a JoinKey.
+	Hashed
+	// Random rows are partitioned across nodes without regard to any JoinKey.
+	Random
+	// Replicated rows are copied in full to every node.
+	Replicated
+)
+
+// Distribution describes where the rows of a relation or intermediate result
+// live. The zero Distribution is Singleton, which is what every relation of a
+// single node database has.
+type Distribution struct {
+	Kind DistributionKind
+	// Key is the JoinKey rows are partitioned on if Kind is Hashed, and 0
+	// otherwise.
+	Key JoinKey
+}
+
+// HashedOn returns the Distribution of rows partitioned on k.
+func HashedOn(k JoinKey) Distribution {
+	return Distribution{Kind: Hashed, Key: k}
+}
+
+func (d Distribution) String() string {
+	switch d.Kind {
+	case Singleton:
+		return "singleton"
+	case Hashed:
+		return fmt.Sprintf("hashed(%d)", int(d.Key))
+	case Random:
+		return "random"
+	case Replicated:
+		return "replicated"
+	}
+	panic(fmt.Sprintf("unknown distribution kind %d", int(d.Kind)))
+}
+
+// SetDistribution records how the rows of r are spread across nodes.
+func (b *Builder) SetDistribution(r RelationID, d Distribution) {
+	if !b.validRelation(r) {
+		return
+	}
+	b.relations[r-1].dist = d
+}
+
+// Distribution returns how the rows of r are spread across nodes.
+func (s *Schema) Distribution(r RelationID) Distribution {
+	return s.Relation(r).dist
+}
+
+// JoinDistribution returns the Distribution of the result of joining a and b
+// when their rows are distributed as da and db, and false if the join can't be
+// performed without first moving some of the rows. A join can be performed in
+// place if one side is replicated and its unmatched rows aren't preserved, if
+// both sides are replicated or on a single node, or if both sides are
+// partitioned on the same key of a predicate between them.
+func (s *Schema) JoinDistribution(a, b RelSet, da, db Distribution) (Distribution, bool) {
+	switch {
+	case da.Kind == Replicated && db.Kind == Replicated:
+		return da, true
+	case da.Kind == Replicated:
+		return db, s.replicable(a, b)
+	case db.Kind == Replicated:
+		return da, s.replicable(b, a)
+	case da.Kind == Singleton && db.Kind == Singleton:
+		return da, true
+	case da.Kind == Hashed && da == db:
+		for _, k := range s.JoinKeys(a, b) {
+			if k == da.Key {
+				return da, true
+			}
+		}
+	}
+	return Distribution{}, false
+}
+
+// replicable returns whether a can be replicated to every node holding some
+// of the rows of b when the two are joined. Each node only sees some of the
+// rows of b, so it can't tell which rows of a match none of them: a must not
+// be a side whose unmatched rows are preserved, nor the left side of a semi
+// join, whose rows would be produced by every node they match on.
+func (s *Schema) replicable(a, b RelSet) bool {
+	o := s.joinOperator(a, b)
+	if o == nil {
+		return true
+	}
+	switch o.kind {
+	case FullOuterJoin:
+		return false
+	case LeftOuterJoin, SemiJoin, AntiJoin:
+		return !o.ses.Intersection(o.left).SubsetOf(a)
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DOT returns the query graph of s in the Graphviz DOT language. Each relation
+// is labelled with its name and cardinality, and each pair of adjacent
+// relations is joined by an edge labelled with the selectivity between them.
+func (s *Schema) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("graph G {\n")
+	for i := 1; i <= s.NumRels(); i++ {
+		r := RelationID(i)
+		label := fmt.Sprintf("%s\n%g", s.Relation(r).Name, s.Cardinality(r))
+		fmt.Fprintf(&buf, "  r%d [label=%q];\n", i, label)
+	}
+	for i := 1; i <= s.NumRels(); i++ {
+		n := s.Neighbours(RelationID(i))
+		for j, ok := n.Next(i + 1); ok; j, ok = n.Next(j + 1) {
+			sel := s.Selectivity(RelationID(i), RelationID(j))
+			fmt.Fprintf(&buf, "  r%d -- r%d [label=\"%g\"];\n", i, j, sel)
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,122 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProblemKind classifies a Problem.
+type ProblemKind int
+
+const (
+	// UnknownRelation is a reference to a RelationID which doesn't exist.
+	UnknownRelation ProblemKind = iota
+	// UnknownColumn is a reference to a ColumnID which doesn't exist.
+	UnknownColumn
+	// UnknownPredicate is a reference to a predicate which doesn't exist.
+	UnknownPredicate
+	// DuplicateName is a relation or column with the same name as another.
+	DuplicateName
+	// SelfJoin is a predicate between a relation and itself.
+	SelfJoin
+	// InvalidSelectivity is a selectivity outside of (0, 1].
+	InvalidSelectivity
+	// InvalidCardinality is a cardinality which isn't positive.
+	InvalidCardinality
+	// InvalidEstimate is a statistic, histogram, error bound or parameter
+	// range which doesn't make sense, or an estimate which can't be made.
+	InvalidEstimate
+	// InvalidConstraint is a key or joint selectivity which doesn't make
+	// sense.
+	InvalidConstraint
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case UnknownRelation:
+		return "unknown relation"
+	case UnknownColumn:
+		return "unknown column"
+	case UnknownPredicate:
+		return "unknown predicate"
+	case DuplicateName:
+		return "duplicate name"
+	case SelfJoin:
+		return "self-join"
+	case InvalidSelectivity:
+		return "invalid selectivity"
+	case InvalidCardinality:
+		return "invalid cardinality"
+	case InvalidEstimate:
+		return "invalid estimate"
+	case InvalidConstraint:
+		return "invalid constraint"
+	}
+	panic(fmt.Sprintf("unknown problem kind %d", int(k)))
+}
+
+// Problem is something wrong with a schema found while building it.
+type Problem struct {
+	Kind    ProblemKind
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Kind, p.Message)
+}
+
+// BuildError is returned by Build when there is anything wrong with the
+// schema. It lists every problem found, in the order the mistakes were made.
+type BuildError struct {
+	Problems []Problem
+}
+
+func (e *BuildError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.String()
+	}
+	return "invalid schema: " + strings.Join(msgs, "; ")
+}
+
+// problemf records a problem with the schema, to be returned by Build. The
+// call which made the mistake otherwise has no effect.
+func (b *Builder) problemf(kind ProblemKind, format string, args ...interface{}) {
+	b.problems.addf(kind, format, args...)
+}
+
+// problemList is a list of problems. Problems found by Build itself are
+// collected in one of their own, so that building again doesn't repeat them.
+type problemList []Problem
+
+func (p *problemList) addf(kind ProblemKind, format string, args ...interface{}) {
+	*p = append(*p, Problem{Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+// validRelation returns whether r exists, recording a problem if not.
+func (b *Builder) validRelation(r RelationID) bool {
+	if int(r)-1 >= len(b.relations) || r < 1 {
+		b.problemf(UnknownRelation, "no relation with id %d", r)
+		return false
+	}
+	return true
+}
+
+// validColumn returns whether c exists, recording a problem if not.
+func (b *Builder) validColumn(c ColumnID) bool {
+	if int(c)-1 >= len(b.columns) || c < 1 {
+		b.problemf(UnknownColumn, "no column with id %d", c)
+		return false
+	}
+	return true
+}
+
+// validSelectivity returns whether sel is in (0, 1], recording a problem if
+// not.
+func (b *Builder) validSelectivity(sel Selectivity) bool {
+	if !(sel > 0 && sel <= 1) {
+		b.problemf(InvalidSelectivity, "selectivity %v is not in (0, 1]", sel)
+		return false
+	}
+	return true
+}
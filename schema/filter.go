@@ -0,0 +1,39 @@
+package schema
+
+// Filter is a predicate on a single relation, such as a.status = 'x', which
+// is applied as it is scanned.
+type Filter struct {
+	Description string
+	Selectivity Selectivity
+}
+
+// AddFilter adds a filter to r which keeps the fraction sel of its rows.
+// description is only used to display the filter.
+func (b *Builder) AddFilter(r RelationID, description string, sel Selectivity) {
+	if !b.validRelation(r) || !b.validSelectivity(sel) {
+		return
+	}
+	rel := &b.relations[r-1]
+	rel.filters = append(rel.filters, Filter{Description: description, Selectivity: sel})
+}
+
+// Filters returns the filters on r.
+func (s *Schema) Filters(r RelationID) []Filter {
+	return s.Relation(r).filters
+}
+
+// BaseCardinality returns the number of rows in r before any filters are
+// applied.
+func (s *Schema) BaseCardinality(r RelationID) Cardinality {
+	return s.Relation(r).card
+}
+
+// FilterSelectivity returns the combined selectivity of the filters on r,
+// assuming they are independent.
+func (s *Schema) FilterSelectivity(r RelationID) Selectivity {
+	var sel Selectivity = 1
+	for _, f := range s.Relation(r).filters {
+		sel *= f.Selectivity
+	}
+	return sel
+}
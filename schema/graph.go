@@ -0,0 +1,168 @@
+package schema
+
+// Neighbourhood returns N(set), the relations adjacent to some relation of set
+// which are not in set themselves.
+func (s *Schema) Neighbourhood(set RelSet) RelSet {
+	var result RelSet
+	for i, ok := set.Next(0); ok; i, ok = set.Next(i + 1) {
+		result.UnionWith(s.Neighbours(RelationID(i)))
+	}
+	result.DifferenceWith(set)
+	return result
+}
+
+// Connected returns whether the relations of set are connected by predicates
+// between relations of set. The empty set is not connected.
+func (s *Schema) Connected(set RelSet) bool {
+	first, ok := set.Next(0)
+	if !ok {
+		return false
+	}
+	reached := S(RelationID(first))
+	frontier := reached
+	for !frontier.Empty() {
+		frontier = s.Neighbourhood(reached).Intersection(set)
+		reached.UnionWith(frontier)
+	}
+	return reached.Equals(set)
+}
+
+// Components returns the connected components of the query graph, ordered by
+// their lowest relation.
+func (s *Schema) Components() []RelSet {
+	var all RelSet
+	all.AddRange(1, s.NumRels())
+
+	var result []RelSet
+	for !all.Empty() {
+		first, _ := all.Next(0)
+		component := S(RelationID(first))
+		for frontier := component; !frontier.Empty(); {
+			frontier = s.Neighbourhood(component)
+			component.UnionWith(frontier)
+		}
+		result = append(result, component)
+		all.DifferenceWith(component)
+	}
+	return result
+}
+
+// ForEachConnectedSubset calls f with each non-empty subset of set which is
+// connected, exactly once each. This is EnumerateCsg from Moerkotte and
+// Neumann's DPccp.
+func (s *Schema) ForEachConnectedSubset(set RelSet, f func(RelSet)) {
+	s.forEachConnectedSubset(set, func(sub RelSet) bool {
+		f(sub)
+		return true
+	})
+}
+
+// forEachConnectedSubset is ForEachConnectedSubset, except that it stops as
+// soon as f returns false, and then returns false itself.
+func (s *Schema) forEachConnectedSubset(set RelSet, f func(RelSet) bool) bool {
+	rels := set.Ordered()
+	for i := len(rels) - 1; i >= 0; i-- {
+		v := S(RelationID(rels[i]))
+		if !f(v) {
+			return false
+		}
+		// Relations numbered lower than v are excluded, so each subset is
+		// only produced starting from its lowest relation.
+		var excluded RelSet
+		for _, r := range rels[:i+1] {
+			excluded.Add(r)
+		}
+		if !s.enumerateConnected(set, v, excluded, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// ForEachCsgCmpPair calls f with each pair of disjoint connected subsets of
+// set which are adjacent to each other, exactly once each, in no particular
+// orientation. These are the pairs of plans a join enumerator has to
+// consider. This is EnumerateCmp from DPccp, applied to each connected subset.
+func (s *Schema) ForEachCsgCmpPair(set RelSet, f func(s1, s2 RelSet)) {
+	s.forEachCsgCmpPair(set, func(s1, s2 RelSet) bool {
+		f(s1, s2)
+		return true
+	})
+}
+
+// forEachCsgCmpPair is ForEachCsgCmpPair, except that it stops as soon as f
+// returns false, and then returns false itself.
+func (s *Schema) forEachCsgCmpPair(set RelSet, f func(s1, s2 RelSet) bool) bool {
+	rels := set.Ordered()
+	return s.forEachConnectedSubset(set, func(s1 RelSet) bool {
+		min, _ := s1.Next(0)
+		excluded := s1.Copy()
+		for _, r := range rels {
+			if r < min {
+				excluded.Add(r)
+			}
+		}
+		n := s.Neighbourhood(s1).Intersection(set).Difference(excluded)
+		ordered := n.Ordered()
+		for i := len(ordered) - 1; i >= 0; i-- {
+			v := S(RelationID(ordered[i]))
+			if !f(s1, v) {
+				return false
+			}
+			ex := excluded.Copy()
+			for _, r := range ordered[:i+1] {
+				ex.Add(r)
+			}
+			if !s.enumerateConnected(set, v, ex, func(s2 RelSet) bool {
+				return f(s1, s2)
+			}) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// enumerateConnected calls f with each connected subset of set formed by
+// extending sub with relations outside of excluded. It stops as soon as f
+// returns false, and then returns false itself.
+func (s *Schema) enumerateConnected(set, sub, excluded RelSet, f func(RelSet) bool) bool {
+	n := s.Neighbourhood(sub).Intersection(set).Difference(excluded)
+	if n.Empty() {
+		return true
+	}
+	if !forEachNonEmptySubset(n, func(ext RelSet) bool {
+		return f(sub.Union(ext))
+	}) {
+		return false
+	}
+	excluded = excluded.Union(n)
+	return forEachNonEmptySubset(n, func(ext RelSet) bool {
+		return s.enumerateConnected(set, sub.Union(ext), excluded, f)
+	})
+}
+
+// forEachNonEmptySubset calls f with each non-empty subset of set, of which
+// there are 2^|set| - 1, so it is up to f to stop early by returning false
+// if set is large. It then returns false itself.
+func forEachNonEmptySubset(set RelSet, f func(RelSet) bool) bool {
+	elems := set.Ordered()
+	// in counts through the subsets in binary, lowest element first.
+	in := make([]bool, len(elems))
+	var sub RelSet
+	for {
+		i := 0
+		for ; i < len(elems) && in[i]; i++ {
+			in[i] = false
+			sub.Remove(elems[i])
+		}
+		if i == len(elems) {
+			return true
+		}
+		in[i] = true
+		sub.Add(elems[i])
+		if !f(sub.Copy()) {
+			return false
+		}
+	}
+}
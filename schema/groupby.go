@@ -0,0 +1,42 @@
+package schema
+
+import "math"
+
+// SetGroupBy declares that the result of the query is grouped on cols, which
+// must all have statistics by the time the schema is built. Its aggregates
+// are assumed to be decomposable, like SUM, COUNT, MIN and MAX, so that rows
+// can be partially aggregated below a join and the partial results combined
+// above it.
+func (b *Builder) SetGroupBy(cols ...ColumnID) {
+	for _, c := range cols {
+		if !b.validColumn(c) {
+			return
+		}
+	}
+	b.groupBy = cols
+}
+
+// GroupBy returns the columns the result of the query is grouped on, or nil if
+// it isn't grouped.
+func (s *Schema) GroupBy() []ColumnID {
+	return s.groupBy
+}
+
+// GroupCardinality estimates the number of groups produced by aggregating the
+// join of set, which has cardinality c, as the product of the number of
+// distinct values of each column it is grouped on: those of the GROUP BY which
+// belong to set, and for each relation it is still to be joined to, the
+// values the join compares, of which there are taken to be 1/sel.
+func (s *Schema) GroupCardinality(set RelSet, c Cardinality) Cardinality {
+	groups := 1.0
+	for _, col := range s.groupBy {
+		if set.Contains(int(s.Column(col).Relation)) {
+			groups *= s.DistinctCount(col, set, c)
+		}
+	}
+	n := s.Neighbourhood(set)
+	for r, ok := n.Next(0); ok; r, ok = n.Next(r + 1) {
+		groups /= float64(s.ComplexSelectivity(set, S(RelationID(r))))
+	}
+	return Cardinality(math.Min(groups, float64(c)))
+}
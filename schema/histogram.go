@@ -0,0 +1,241 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Histogram describes the distribution of the non-null values of a numeric
+// column as a list of buckets. Every row falls into exactly one bucket.
+type Histogram struct {
+	Buckets []Bucket
+}
+
+// Bucket is a bucket of a Histogram holding Count rows with Distinct
+// distinct values in [Lo, Hi], assumed to be spread uniformly over the range.
+// A bucket with Lo == Hi holds a single value.
+//
+// The range buckets of a histogram can't overlap each other, but may contain
+// single-value buckets, as in an end-biased histogram whose frequent values
+// are kept separately from the rest.
+type Bucket struct {
+	Lo, Hi   float64
+	Count    float64
+	Distinct float64
+}
+
+func (b Bucket) single() bool {
+	return b.Lo == b.Hi
+}
+
+// NewEquiDepthHistogram returns a histogram of values with at most n range
+// buckets holding roughly the same number of rows. Equal values always fall
+// into the same bucket. n must be positive.
+func NewEquiDepthHistogram(values []float64, n int) Histogram {
+	if n < 1 {
+		panic(fmt.Sprintf("can't make a histogram with %d buckets", n))
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var h Histogram
+	depth := int(math.Ceil(float64(len(sorted)) / float64(n)))
+	for start := 0; start < len(sorted); {
+		end := start + depth
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		for end < len(sorted) && sorted[end] == sorted[end-1] {
+			end++
+		}
+		h.Buckets = append(h.Buckets, Bucket{
+			Lo:       sorted[start],
+			Hi:       sorted[end-1],
+			Count:    float64(end - start),
+			Distinct: float64(countDistinct(sorted[start:end])),
+		})
+		start = end
+	}
+	return h
+}
+
+// NewEndBiasedHistogram returns a histogram of values which keeps a bucket for
+// each of its n most frequent values, and a single range bucket for the rest.
+func NewEndBiasedHistogram(values []float64, n int) Histogram {
+	counts := make(map[float64]float64)
+	for _, v := range values {
+		counts[v]++
+	}
+	distinct := make([]float64, 0, len(counts))
+	for v := range counts {
+		distinct = append(distinct, v)
+	}
+	sort.Slice(distinct, func(i, j int) bool {
+		if counts[distinct[i]] != counts[distinct[j]] {
+			return counts[distinct[i]] > counts[distinct[j]]
+		}
+		return distinct[i] < distinct[j]
+	})
+	if n > len(distinct) {
+		n = len(distinct)
+	}
+
+	var h Histogram
+	for _, v := range distinct[:n] {
+		h.Buckets = append(h.Buckets, Bucket{Lo: v, Hi: v, Count: counts[v], Distinct: 1})
+	}
+	if rest := distinct[n:]; len(rest) > 0 {
+		b := Bucket{Lo: math.Inf(1), Hi: math.Inf(-1), Distinct: float64(len(rest))}
+		for _, v := range rest {
+			b.Lo, b.Hi = math.Min(b.Lo, v), math.Max(b.Hi, v)
+			b.Count += counts[v]
+		}
+		h.Buckets = append(h.Buckets, b)
+	}
+	sort.SliceStable(h.Buckets, func(i, j int) bool {
+		return h.Buckets[i].Lo < h.Buckets[j].Lo
+	})
+	return h
+}
+
+func countDistinct(sorted []float64) int {
+	n := 0
+	for i := range sorted {
+		if i == 0 || sorted[i] != sorted[i-1] {
+			n++
+		}
+	}
+	return n
+}
+
+// rows returns the number of rows in h.
+func (h Histogram) rows() float64 {
+	total := 0.0
+	for _, b := range h.Buckets {
+		total += b.Count
+	}
+	return total
+}
+
+func (h Histogram) validate() error {
+	if len(h.Buckets) == 0 {
+		return fmt.Errorf("no buckets")
+	}
+	var ranges []Bucket
+	singles := make(map[float64]bool)
+	for _, b := range h.Buckets {
+		if b.Hi < b.Lo || b.Count <= 0 || b.Distinct < 1 || b.Distinct > b.Count {
+			return fmt.Errorf("invalid bucket %+v", b)
+		}
+		if b.single() {
+			if b.Distinct != 1 || singles[b.Lo] {
+				return fmt.Errorf("invalid bucket %+v", b)
+			}
+			singles[b.Lo] = true
+			continue
+		}
+		ranges = append(ranges, b)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Lo < ranges[j].Lo })
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].Lo < ranges[i-1].Hi {
+			return fmt.Errorf("overlapping buckets %+v and %+v", ranges[i-1], ranges[i])
+		}
+	}
+	return nil
+}
+
+// SetHistogram records a histogram of the values of c. Equalities between two
+// columns with histograms have their selectivity estimated from them, even if
+// one was given explicitly. If c has no statistics, they are derived from h.
+func (b *Builder) SetHistogram(c ColumnID, h Histogram) {
+	if !b.validColumn(c) {
+		return
+	}
+	if err := h.validate(); err != nil {
+		b.problemf(InvalidEstimate, "histogram of column %d: %v", c, err)
+		return
+	}
+	col := &b.columns[c-1]
+	col.hist = &h
+	if !col.hasStats {
+		col.stats = ColumnStats{Min: math.Inf(1), Max: math.Inf(-1)}
+		for _, bucket := range h.Buckets {
+			col.stats.DistinctCount += bucket.Distinct
+			col.stats.Min = math.Min(col.stats.Min, bucket.Lo)
+			col.stats.Max = math.Max(col.stats.Max, bucket.Hi)
+		}
+		col.hasStats = true
+	}
+}
+
+// Histogram returns the histogram of c, and false if it has none.
+func (s *Schema) Histogram(c ColumnID) (Histogram, bool) {
+	col := s.Column(c)
+	if col.hist == nil {
+		return Histogram{}, false
+	}
+	return *col.hist, true
+}
+
+// estimateHistogramEquality estimates the selectivity of x = y among the rows
+// of x and y by aligning their buckets. Single values are matched with each
+// other exactly, and with range buckets assuming each value in the range is
+// equally frequent. The range buckets are split at every boundary of either
+// histogram, and each piece of x is matched against the overlapping piece of
+// y as 1/max(ndv).
+func estimateHistogramEquality(x, y Histogram) Selectivity {
+	matches := 0.0
+
+	var bounds []float64
+	for _, h := range []Histogram{x, y} {
+		for _, b := range h.Buckets {
+			if !b.single() {
+				bounds = append(bounds, b.Lo, b.Hi)
+			}
+		}
+	}
+	sort.Float64s(bounds)
+	for i := 1; i < len(bounds); i++ {
+		lo, hi := bounds[i-1], bounds[i]
+		if lo == hi {
+			continue
+		}
+		xRows, xDistinct, xOk := x.piece(lo, hi)
+		yRows, yDistinct, yOk := y.piece(lo, hi)
+		if xOk && yOk {
+			matches += xRows * yRows / math.Max(xDistinct, yDistinct)
+		}
+	}
+
+	for _, xb := range x.Buckets {
+		for _, yb := range y.Buckets {
+			switch {
+			case xb.single() && yb.single():
+				if xb.Lo == yb.Lo {
+					matches += xb.Count * yb.Count
+				}
+			case xb.single() && yb.Lo <= xb.Lo && xb.Lo <= yb.Hi:
+				matches += xb.Count * yb.Count / yb.Distinct
+			case yb.single() && xb.Lo <= yb.Lo && yb.Lo <= xb.Hi:
+				matches += xb.Count * yb.Count / xb.Distinct
+			}
+		}
+	}
+
+	return Selectivity(math.Min(matches/(x.rows()*y.rows()), 1))
+}
+
+// piece returns the number of rows and distinct values of h in the range
+// [lo, hi], which must lie within a single range bucket, and false if there is
+// no such bucket.
+func (h Histogram) piece(lo, hi float64) (float64, float64, bool) {
+	for _, b := range h.Buckets {
+		if !b.single() && b.Lo <= lo && hi <= b.Hi {
+			frac := (hi - lo) / (b.Hi - b.Lo)
+			return b.Count * frac, math.Max(b.Distinct*frac, 1), true
+		}
+	}
+	return 0, 0, false
+}
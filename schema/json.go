@@ -0,0 +1,245 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A schema is encoded as JSON as an object of the form
+//
+//	{
+//	  "relations": [
+//	    {
+//	      "name": "A",
+//	      "cardinality": 1000,
+//	      "columns": ["x", "y"],
+//	      "stats": {"x": {"distinctCount": 100, "nullFraction": 0.1}},
+//	      "filters": [{"description": "a.y > 5", "selectivity": 0.5}]
+//	    },
+//	    {"name": "B", "cardinality": 100, "columns": ["x"]}
+//	  ],
+//	  "predicates": [
+//	    {"left": "A", "right": "B", "selectivity": 0.01, "key": 1}
+//	  ],
+//	  "equalities": [
+//	    {"left": "A.x", "right": "B.x", "selectivity": 0.01}
+//	  ]
+//	}
+//
+// Relations are referred to by name, and columns by their relation's name and
+// their own, separated by a dot. Predicates with the same key compare the
+// same values; a predicate with no key gets a key of its own. An equality
+// with no selectivity has it estimated. The stats of a column may also have
+// a "min" and a "max".
+//
+// Only the parts of a schema shown above are encoded. Everything else, such
+// as histograms, foreign keys, constraints and error bounds, is lost, so
+// estimated selectivities are encoded as they were estimated. That includes
+// the estimate for each pair of columns which are only implied to be equal,
+// which is encoded as an equality of its own after the others. Outer, semi
+// and anti joins, ORDER BY and GROUP BY change the query itself rather than
+// how it is estimated, and can't be encoded at all.
+
+type jsonSchema struct {
+	Relations  []jsonRelation  `json:"relations"`
+	Predicates []jsonPredicate `json:"predicates,omitempty"`
+	Equalities []jsonEquality  `json:"equalities,omitempty"`
+}
+
+type jsonRelation struct {
+	Name        RelationName                   `json:"name"`
+	Cardinality Cardinality                    `json:"cardinality"`
+	Columns     []ColumnName                   `json:"columns,omitempty"`
+	Stats       map[ColumnName]jsonColumnStats `json:"stats,omitempty"`
+	Filters     []jsonFilter                   `json:"filters,omitempty"`
+}
+
+type jsonColumnStats struct {
+	DistinctCount float64 `json:"distinctCount"`
+	NullFraction  float64 `json:"nullFraction,omitempty"`
+	Min           float64 `json:"min,omitempty"`
+	Max           float64 `json:"max,omitempty"`
+}
+
+type jsonFilter struct {
+	Description string      `json:"description"`
+	Selectivity Selectivity `json:"selectivity"`
+}
+
+type jsonPredicate struct {
+	Left        RelationName `json:"left"`
+	Right       RelationName `json:"right"`
+	Selectivity Selectivity  `json:"selectivity"`
+	Key         JoinKey      `json:"key,omitempty"`
+}
+
+type jsonEquality struct {
+	Left        string      `json:"left"`
+	Right       string      `json:"right"`
+	Selectivity Selectivity `json:"selectivity,omitempty"`
+}
+
+// MarshalJSON encodes s in the format described above. It returns an error
+// if s has anything which would make it a different query once loaded.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	for _, o := range s.operators {
+		if o.kind != InnerJoin {
+			return nil, fmt.Errorf("can't encode a %s in JSON", o.kind)
+		}
+	}
+	if s.orderBy != 0 {
+		return nil, fmt.Errorf("can't encode an ORDER BY in JSON")
+	}
+	if len(s.groupBy) > 0 {
+		return nil, fmt.Errorf("can't encode a GROUP BY in JSON")
+	}
+
+	var js jsonSchema
+	for i := range s.relations {
+		r := &s.relations[i]
+		jr := jsonRelation{Name: r.Name, Cardinality: r.card}
+		for _, c := range s.columns {
+			if c.Relation != r.id {
+				continue
+			}
+			jr.Columns = append(jr.Columns, c.Name)
+			if c.hasStats {
+				if jr.Stats == nil {
+					jr.Stats = make(map[ColumnName]jsonColumnStats)
+				}
+				jr.Stats[c.Name] = jsonColumnStats(c.stats)
+			}
+		}
+		for _, f := range r.filters {
+			jr.Filters = append(jr.Filters, jsonFilter(f))
+		}
+		js.Relations = append(js.Relations, jr)
+	}
+	for _, p := range s.predicates {
+		js.Predicates = append(js.Predicates, jsonPredicate{
+			Left:        s.Relation(p.X).Name,
+			Right:       s.Relation(p.Y).Name,
+			Selectivity: p.Selectivity,
+			Key:         p.Key,
+		})
+	}
+	for _, e := range s.equalities {
+		js.Equalities = append(js.Equalities, jsonEquality{
+			Left:        s.columnName(e.x),
+			Right:       s.columnName(e.y),
+			Selectivity: s.equalitySelectivity(e.id),
+		})
+	}
+	for i := range s.classes {
+		for _, e := range s.classes[i].edges {
+			if e.id == 0 {
+				js.Equalities = append(js.Equalities, jsonEquality{
+					Left:        s.columnName(e.x),
+					Right:       s.columnName(e.y),
+					Selectivity: e.sel,
+				})
+			}
+		}
+	}
+	return json.Marshal(js)
+}
+
+// equalitySelectivity returns the selectivity of the equality id, which was
+// estimated if it wasn't given explicitly.
+func (s *Schema) equalitySelectivity(id EqualityID) Selectivity {
+	for i := range s.classes {
+		for _, e := range s.classes[i].edges {
+			if e.id == id {
+				return e.sel
+			}
+		}
+	}
+	return s.equalities[id-1].sel
+}
+
+func (s *Schema) columnName(c ColumnID) string {
+	col := s.Column(c)
+	return fmt.Sprintf("%s.%s", s.Relation(col.Relation).Name, col.Name)
+}
+
+// LoadJSON builds a schema from its encoding in the format described above.
+// It returns an error if data isn't valid JSON, and otherwise a *BuildError
+// if there is anything wrong with the schema.
+func LoadJSON(data []byte) (*Schema, error) {
+	var js jsonSchema
+	if err := json.Unmarshal(data, &js); err != nil {
+		return nil, err
+	}
+
+	b := NewBuilder()
+	rels := make(map[RelationName]RelationID)
+	cols := make(map[string]ColumnID)
+	for _, jr := range js.Relations {
+		r := b.AddRelation(jr.Name, jr.Cardinality)
+		rels[jr.Name] = r
+		for _, name := range jr.Columns {
+			c := b.AddColumn(r, name)
+			cols[fmt.Sprintf("%s.%s", jr.Name, name)] = c
+			if stats, ok := jr.Stats[name]; ok && c != 0 {
+				b.SetColumnStats(c, ColumnStats(stats))
+			}
+		}
+		var unknown []string
+		for name := range jr.Stats {
+			if _, ok := cols[fmt.Sprintf("%s.%s", jr.Name, name)]; !ok {
+				unknown = append(unknown, fmt.Sprintf("%s.%s", jr.Name, name))
+			}
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			b.problemf(UnknownColumn, "no column named %s", name)
+		}
+		for _, f := range jr.Filters {
+			b.AddFilter(r, f.Description, f.Selectivity)
+		}
+	}
+
+	relation := func(name RelationName) RelationID {
+		r, ok := rels[name]
+		if !ok {
+			b.problemf(UnknownRelation, "no relation named %s", name)
+		}
+		return r
+	}
+	column := func(name string) ColumnID {
+		c, ok := cols[name]
+		if !ok {
+			if !strings.Contains(name, ".") {
+				b.problemf(UnknownColumn, "column %s isn't qualified with its relation", name)
+			} else {
+				b.problemf(UnknownColumn, "no column named %s", name)
+			}
+		}
+		return c
+	}
+
+	keys := make(map[JoinKey]JoinKey)
+	for _, jp := range js.Predicates {
+		l, r := relation(jp.Left), relation(jp.Right)
+		if l == 0 || r == 0 {
+			continue
+		}
+		k, ok := keys[jp.Key]
+		if !ok || jp.Key == 0 {
+			k = b.NewJoinKey()
+			keys[jp.Key] = k
+		}
+		b.AddPredicateOnKey(l, r, jp.Selectivity, k)
+	}
+	for _, je := range js.Equalities {
+		l, r := column(je.Left), column(je.Right)
+		if l == 0 || r == 0 {
+			continue
+		}
+		b.AddEquality(l, r, je.Selectivity)
+	}
+
+	return b.Build()
+}
@@ -0,0 +1,134 @@
+package schema
+
+import "math"
+
+// ForeignKey is a constraint that the values of Columns, which belong to a
+// single relation, appear in the primary key of References.
+type ForeignKey struct {
+	Columns    []ColumnID
+	References RelationID
+}
+
+// SetPrimaryKey declares that no two rows of r have the same values of cols.
+func (b *Builder) SetPrimaryKey(r RelationID, cols ...ColumnID) {
+	if !b.validRelation(r) {
+		return
+	}
+	if len(cols) == 0 {
+		b.problemf(InvalidConstraint, "empty primary key of %s", b.relation(r).Name)
+		return
+	}
+	for _, c := range cols {
+		if !b.validColumn(c) {
+			return
+		}
+		if b.column(c).Relation != r {
+			b.problemf(InvalidConstraint, "primary key column %d is not in %s", c, b.relation(r).Name)
+			return
+		}
+	}
+	b.relations[r-1].key = cols
+}
+
+// AddForeignKey declares that cols, which must all belong to the same
+// relation, reference the primary key of ref, column for column.
+//
+// An estimated equality between a column of a foreign key and the column of
+// the primary key it references has a selectivity of 1/|ref|, so that each row
+// matches exactly one row of ref. The selectivity of each column of a
+// composite key is chosen so that the equalities on all of them together have
+// that selectivity.
+func (b *Builder) AddForeignKey(ref RelationID, cols ...ColumnID) {
+	if !b.validRelation(ref) {
+		return
+	}
+	key := b.relation(ref).key
+	if key == nil {
+		b.problemf(InvalidConstraint, "%s has no primary key", b.relation(ref).Name)
+		return
+	}
+	if len(cols) != len(key) {
+		b.problemf(InvalidConstraint, "foreign key has %d columns, but the primary key of %s has %d", len(cols), b.relation(ref).Name, len(key))
+		return
+	}
+	for _, c := range cols {
+		if !b.validColumn(c) {
+			return
+		}
+		if b.column(c).Relation != b.column(cols[0]).Relation {
+			b.problemf(InvalidConstraint, "foreign key columns %v belong to different relations", cols)
+			return
+		}
+	}
+	b.foreignKeys = append(b.foreignKeys, ForeignKey{Columns: cols, References: ref})
+}
+
+// estimateForeignKey returns the selectivity of x = y if one of them is part of
+// a foreign key referencing the other.
+func (b *Builder) estimateForeignKey(x, y ColumnID) (Selectivity, bool) {
+	for _, fk := range b.foreignKeys {
+		key := b.relation(fk.References).key
+		for i, c := range fk.Columns {
+			if c == x && key[i] == y || c == y && key[i] == x {
+				card := float64(b.relation(fk.References).card)
+				return Selectivity(math.Pow(1/card, 1/float64(len(key)))), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// PrimaryKey returns the primary key of r, or nil if it has none.
+func (s *Schema) PrimaryKey(r RelationID) []ColumnID {
+	return s.Relation(r).key
+}
+
+// ForeignKeys returns the foreign keys of the schema.
+func (s *Schema) ForeignKeys() []ForeignKey {
+	return s.foreignKeys
+}
+
+// joinedOn returns whether every column of fk is equal to the column of the
+// primary key it references.
+func (s *Schema) joinedOn(fk ForeignKey) bool {
+	for i, c := range s.PrimaryKey(fk.References) {
+		if s.ColumnKey(fk.Columns[i]) != s.ColumnKey(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueOn returns whether the rows of the join of set are unique on the
+// primary key of r: it is r along with relations which r reaches through
+// foreign keys joined to their primary keys, each of which can only add a
+// single row to each of the rows of r.
+func (s *Schema) uniqueOn(set RelSet, r RelationID) bool {
+	reached := S(r)
+	for grew := true; grew; {
+		grew = false
+		for _, fk := range s.foreignKeys {
+			from := s.Column(fk.Columns[0]).Relation
+			if reached.Contains(int(from)) && set.Contains(int(fk.References)) &&
+				!reached.Contains(int(fk.References)) && s.joinedOn(fk) {
+				reached.Add(int(fk.References))
+				grew = true
+			}
+		}
+	}
+	return reached.Equals(set)
+}
+
+// NonExpanding returns whether joining a and b is known to produce no more
+// rows than a, because a foreign key of a references the primary key of a
+// relation in b, and the rows of b are unique on that key.
+func (s *Schema) NonExpanding(a, b RelSet) bool {
+	for _, fk := range s.foreignKeys {
+		from := s.Column(fk.Columns[0]).Relation
+		if a.Contains(int(from)) && b.Contains(int(fk.References)) &&
+			s.joinedOn(fk) && s.uniqueOn(b, fk.References) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+package schema
+
+import "fmt"
+
+// JoinKind is the kind of a join: inner, one of the outer joins, or a semi or
+// anti join.
+type JoinKind int
+
+const (
+	InnerJoin JoinKind = iota
+	// LeftOuterJoin preserves the rows of its left side which match no row
+	// of its right side, padding them with nulls.
+	LeftOuterJoin
+	// RightOuterJoin preserves the unmatched rows of its right side.
+	RightOuterJoin
+	// FullOuterJoin preserves the unmatched rows of both sides.
+	FullOuterJoin
+	// SemiJoin produces the rows of its left side which match some row of its
+	// right side, as for EXISTS and IN subqueries.
+	SemiJoin
+	// AntiJoin produces the rows of its left side which match no row of its
+	// right side, as for NOT EXISTS subqueries.
+	AntiJoin
+)
+
+func (k JoinKind) String() string {
+	switch k {
+	case InnerJoin:
+		return "inner join"
+	case LeftOuterJoin:
+		return "left outer join"
+	case RightOuterJoin:
+		return "right outer join"
+	case FullOuterJoin:
+		return "full outer join"
+	case SemiJoin:
+		return "semi join"
+	case AntiJoin:
+		return "anti join"
+	}
+	panic(fmt.Sprintf("unknown join kind %d", int(k)))
+}
+
+// operator is a join of the query as written. Outer joins are added with
+// AddOuterJoin, semi and anti joins with AddSemiJoin and AddAntiJoin, and
+// every other pair of adjacent relations is an inner join.
+// A right outer join is kept as a left outer join with its sides swapped.
+//
+// Operators are reordered using the conflict detector CD-C of Moerkotte,
+// Fender and Eich, "On the correct and complete enumeration of the core search
+// space". Its predicates are assumed to reject nulls.
+type operator struct {
+	kind JoinKind
+	// left and right are the relations below each side of the operator. For an
+	// inner join they are the outer joins, or single relations, containing
+	// the two relations it compares.
+	left, right RelSet
+	// ses is the set of relations referenced by the operator's predicates.
+	ses RelSet
+	// rules are the conflicts found with the operators below it.
+	rules []conflictRule
+}
+
+// conflictRule requires that a join which includes any relation of from
+// includes all of to.
+type conflictRule struct {
+	from, to RelSet
+}
+
+// AddOuterJoin declares that the predicates between left and right, which
+// must all have been added already, form an outer join of kind of the
+// relations left and right, as they are joined in the query as written.
+// Outer joins must nest: two of them either share no relations, or one lies
+// entirely within one side of the other.
+func (b *Builder) AddOuterJoin(kind JoinKind, left, right RelSet) {
+	if kind != LeftOuterJoin && kind != RightOuterJoin && kind != FullOuterJoin {
+		b.problemf(InvalidConstraint, "%s is not an outer join", kind)
+		return
+	}
+	if kind == RightOuterJoin {
+		kind, left, right = LeftOuterJoin, right, left
+	}
+	b.addOperator(kind, left, right)
+}
+
+// AddSemiJoin declares that the predicates between left and right, which must
+// all have been added already, form a semi join of the relations left and
+// right, as they are joined in the query as written. The relations of right
+// can't be joined to anything but left.
+func (b *Builder) AddSemiJoin(left, right RelSet) {
+	b.addOperator(SemiJoin, left, right)
+}
+
+// AddAntiJoin is like AddSemiJoin, but for an anti join.
+func (b *Builder) AddAntiJoin(left, right RelSet) {
+	b.addOperator(AntiJoin, left, right)
+}
+
+func (b *Builder) addOperator(kind JoinKind, left, right RelSet) {
+	for _, set := range []RelSet{left, right} {
+		if set.Empty() {
+			b.problemf(InvalidConstraint, "empty side of %s", kind)
+			return
+		}
+		for i, ok := set.Next(0); ok; i, ok = set.Next(i + 1) {
+			if !b.validRelation(RelationID(i)) {
+				return
+			}
+		}
+	}
+	if left.Intersects(right) {
+		b.problemf(InvalidConstraint, "sides %s and %s of %s overlap", left, right, kind)
+		return
+	}
+	b.outerJoins = append(b.outerJoins, operator{kind: kind, left: left, right: right})
+}
+
+// buildOperators computes the operators of s and the conflicts between them.
+// It does nothing if there are no outer joins, since inner joins can be
+// reordered freely.
+func (b *Builder) buildOperators(s *Schema, problems *problemList) {
+	if len(b.outerJoins) == 0 {
+		return
+	}
+	s.operators = append([]operator(nil), b.outerJoins...)
+	s.pairOps = make(map[int]int)
+
+	for i := range s.operators {
+		o := &s.operators[i]
+		for j := range s.operators[:i] {
+			p := &s.operators[j]
+			t, u := o.left.Union(o.right), p.left.Union(p.right)
+			if t.Intersects(u) && !t.SubsetOf(p.left) && !t.SubsetOf(p.right) &&
+				!u.SubsetOf(o.left) && !u.SubsetOf(o.right) {
+				problems.addf(InvalidConstraint, "outer joins of %s and %s don't nest", t, u)
+				return
+			}
+		}
+		for _, side := range []RelSet{o.left, o.right} {
+			if !s.Connected(side) {
+				problems.addf(InvalidConstraint, "side %s of %s isn't connected", side, o.kind)
+				return
+			}
+		}
+		if o.kind == SemiJoin || o.kind == AntiJoin {
+			if n := s.Neighbourhood(o.right); !n.SubsetOf(o.left) {
+				problems.addf(InvalidConstraint, "right side %s of %s is joined to %s", o.right, o.kind, n.Difference(o.left))
+				return
+			}
+		}
+	}
+
+	for x := 1; x <= s.NumRels(); x++ {
+		n := s.neighbours[x-1]
+		for y, ok := n.Next(x + 1); ok; y, ok = n.Next(y + 1) {
+			idx := s.crossedOperator(RelationID(x), RelationID(y))
+			if idx == -1 {
+				level := s.level(RelationID(x), RelationID(y))
+				idx = len(s.operators)
+				s.operators = append(s.operators, operator{
+					kind:  InnerJoin,
+					left:  s.unit(RelationID(x), level),
+					right: s.unit(RelationID(y), level),
+				})
+			}
+			s.operators[idx].ses.Add(x)
+			s.operators[idx].ses.Add(y)
+			s.pairOps[pair(RelationID(x), RelationID(y))] = idx
+		}
+	}
+
+	for i := range s.operators {
+		o := &s.operators[i]
+		if o.kind != InnerJoin && o.ses.Empty() {
+			problems.addf(InvalidConstraint, "no predicate between the sides %s and %s of %s", o.left, o.right, o.kind)
+			return
+		}
+		for j := range s.operators {
+			p := &s.operators[j]
+			t := p.left.Union(p.right)
+			switch {
+			case i == j:
+			case t.SubsetOf(o.left):
+				o.rules = append(o.rules, conflicts(p, o, true)...)
+			case t.SubsetOf(o.right):
+				o.rules = append(o.rules, conflicts(p, o, false)...)
+			}
+		}
+	}
+}
+
+// crossedOperator returns the index of the outer join with x and y on
+// opposite sides, or -1 if there isn't one.
+func (s *Schema) crossedOperator(x, y RelationID) int {
+	for i := range s.operators {
+		o := &s.operators[i]
+		if o.kind == InnerJoin {
+			continue
+		}
+		if o.left.Contains(int(x)) && o.right.Contains(int(y)) ||
+			o.left.Contains(int(y)) && o.right.Contains(int(x)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// level returns the relations of the smallest side of an outer join which
+// contains both x and y, or every relation if there isn't one. An inner join
+// of x and y is below that outer join.
+func (s *Schema) level(x, y RelationID) RelSet {
+	var result RelSet
+	result.AddRange(1, s.NumRels())
+	for i := range s.operators {
+		o := &s.operators[i]
+		if o.kind == InnerJoin {
+			continue
+		}
+		for _, side := range []RelSet{o.left, o.right} {
+			if side.Contains(int(x)) && side.Contains(int(y)) && side.Len() < result.Len() {
+				result = side
+			}
+		}
+	}
+	return result
+}
+
+// unit returns the relations of the largest outer join within level which
+// contains r, or just r if there isn't one.
+func (s *Schema) unit(r RelationID, level RelSet) RelSet {
+	result := S(r)
+	for i := range s.operators {
+		o := &s.operators[i]
+		t := o.left.Union(o.right)
+		if o.kind != InnerJoin && t.Contains(int(r)) && t.SubsetOf(level) && t.Len() > result.Len() {
+			result = t
+		}
+	}
+	return result
+}
+
+// assoc, lAsscom and rAsscom record, for each pair of kinds of join a and b
+// whose predicates reject nulls, whether
+//
+//	assoc:    (e1 a e2) b e3 = e1 a (e2 b e3)
+//	l-asscom: (e1 a e2) b e3 = (e1 b e3) a e2
+//	r-asscom: e1 a (e2 b e3) = e2 b (e1 a e3)
+//
+// indexed by joinIndex(a) and then joinIndex(b).
+var (
+	assoc = [5][5]bool{
+		{true, true, true, true, false},
+		{false, false, false, false, false},
+		{false, false, false, false, false},
+		{false, false, false, true, false},
+		{false, false, false, true, true},
+	}
+	lAsscom = [5][5]bool{
+		{true, true, true, true, false},
+		{true, true, true, true, false},
+		{true, true, true, true, false},
+		{true, true, true, true, true},
+		{false, false, false, true, true},
+	}
+	rAsscom = [5][5]bool{
+		{true, false, false, false, false},
+		{false, false, false, false, false},
+		{false, false, false, false, false},
+		{false, false, false, false, false},
+		{false, false, false, false, true},
+	}
+)
+
+// joinIndex orders the kinds of join as the tables above: ⋈, ⋉, ▷, ⟕ and ⟗.
+func joinIndex(k JoinKind) int {
+	switch k {
+	case InnerJoin:
+		return 0
+	case SemiJoin:
+		return 1
+	case AntiJoin:
+		return 2
+	case LeftOuterJoin:
+		return 3
+	}
+	return 4
+}
+
+// conflicts returns the rules which stop the operator b from being reordered
+// with the operator a below it in ways which would change the result. a is
+// below the left side of b if left is true, and otherwise below the right.
+func conflicts(a, b *operator, left bool) []conflictRule {
+	ai, bi := joinIndex(a.kind), joinIndex(b.kind)
+	var rules []conflictRule
+	if left {
+		if !assoc[ai][bi] {
+			rules = append(rules, conflictRule{from: a.right, to: a.left})
+		}
+		if !lAsscom[ai][bi] {
+			rules = append(rules, conflictRule{from: a.left, to: a.right})
+		}
+	} else {
+		if !assoc[bi][ai] {
+			rules = append(rules, conflictRule{from: a.left, to: a.right})
+		}
+		if !rAsscom[bi][ai] {
+			rules = append(rules, conflictRule{from: a.right, to: a.left})
+		}
+	}
+	return rules
+}
+
+// joinOperators returns the indexes of the operators applied by a join of a
+// and b: those of the pairs of adjacent relations on either side.
+func (s *Schema) joinOperators(a, b RelSet) []int {
+	var result []int
+	for i, ok := a.Next(0); ok; i, ok = a.Next(i + 1) {
+		adj := s.neighbours[i-1].Intersection(b)
+		for j, ok := adj.Next(0); ok; j, ok = adj.Next(j + 1) {
+			idx := s.pairOps[pair(RelationID(i), RelationID(j))]
+			found := false
+			for _, k := range result {
+				found = found || k == idx
+			}
+			if !found {
+				result = append(result, idx)
+			}
+		}
+	}
+	return result
+}
+
+// CanJoin returns whether a and b can be joined to each other without
+// changing the result of the query. It is always true if there are no outer,
+// semi or anti joins. Those can only be applied on their own, once the
+// relations their predicates reference are on the correct sides, and any
+// operator can only be applied once those it conflicts with allow it. The
+// left side of a semi or anti join must be a.
+func (s *Schema) CanJoin(a, b RelSet) bool {
+	if len(s.operators) == 0 {
+		return true
+	}
+	ops := s.joinOperators(a, b)
+	set := a.Union(b)
+	for _, idx := range ops {
+		o := &s.operators[idx]
+		if o.kind != InnerJoin {
+			if len(ops) > 1 {
+				return false
+			}
+			l, r := o.ses.Intersection(o.left), o.ses.Intersection(o.right)
+			flippable := o.kind != SemiJoin && o.kind != AntiJoin
+			if !(l.SubsetOf(a) && r.SubsetOf(b)) && !(flippable && l.SubsetOf(b) && r.SubsetOf(a)) {
+				return false
+			}
+		}
+		for _, rule := range o.rules {
+			if rule.from.Intersects(set) && !rule.to.SubsetOf(set) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// JoinKind returns the kind of the join of a, on the left, and b, on the
+// right. It is a RightOuterJoin if the rows of b are preserved. A semi or anti
+// join is reported as such whichever side its left side is on.
+func (s *Schema) JoinKind(a, b RelSet) JoinKind {
+	o := s.joinOperator(a, b)
+	switch {
+	case o == nil:
+		return InnerJoin
+	case o.kind == LeftOuterJoin && !o.ses.Intersection(o.left).SubsetOf(a):
+		return RightOuterJoin
+	}
+	return o.kind
+}
+
+// joinOperator returns the outer, semi or anti join applied by a join of a and
+// b, or nil if there isn't one.
+func (s *Schema) joinOperator(a, b RelSet) *operator {
+	if len(s.operators) == 0 {
+		return nil
+	}
+	for _, idx := range s.joinOperators(a, b) {
+		if o := &s.operators[idx]; o.kind != InnerJoin {
+			return o
+		}
+	}
+	return nil
+}
+
+// DistinctCardinality returns the estimated number of rows of set, which has
+// cardinality c, once duplicates of the values compared by the semi join
+// whose right side is set are removed. Joining the left side of the semi join
+// to them instead produces the same rows. It is c if set isn't the right side
+// of a semi join.
+func (s *Schema) DistinctCardinality(set RelSet, c Cardinality) Cardinality {
+	for i := range s.operators {
+		o := &s.operators[i]
+		if o.kind == SemiJoin && o.right.Equals(set) {
+			// Each distinct value matches a fraction sel of the left side.
+			sel := s.ComplexSelectivity(o.left, o.right)
+			return minCardinality(c, Cardinality(1/float64(sel)))
+		}
+	}
+	return c
+}
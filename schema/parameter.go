@@ -0,0 +1,48 @@
+package schema
+
+import "fmt"
+
+// Parameter is a predicate whose selectivity isn't known until the query is
+// executed, such as one which compares against a placeholder in a prepared
+// statement. Its selectivity is somewhere between Lo and Hi.
+type Parameter struct {
+	X, Y   RelationID
+	Lo, Hi Selectivity
+	// idx is the index of the predicate in the schema.
+	idx int
+}
+
+// SetParameter marks the selectivity of the last predicate added between x
+// and y as a Parameter in the range [lo, hi]. The selectivity the predicate
+// was added with is still used by anything which doesn't consider parameters.
+func (b *Builder) SetParameter(x, y RelationID, lo, hi Selectivity) {
+	idx, ok := b.lastPredicate(x, y)
+	if !ok {
+		return
+	}
+	if !(lo > 0 && lo <= hi && hi <= 1) {
+		b.problemf(InvalidEstimate, "invalid parameter range [%v, %v]", lo, hi)
+		return
+	}
+	b.parameters = append(b.parameters, Parameter{X: x, Y: y, Lo: lo, Hi: hi, idx: idx})
+}
+
+// Parameters returns the Parameters of the schema, in the order they were
+// added.
+func (s *Schema) Parameters() []Parameter {
+	return s.parameters
+}
+
+// Bind returns a copy of s in which the selectivity of the i-th Parameter is
+// vals[i].
+func (s *Schema) Bind(vals []Selectivity) *Schema {
+	if len(vals) != len(s.parameters) {
+		panic(fmt.Sprintf("expected %d parameter values, got %d", len(s.parameters), len(vals)))
+	}
+	bound := *s
+	bound.predicates = append([]Predicate(nil), s.predicates...)
+	for i, p := range s.parameters {
+		bound.predicates[p.idx].Selectivity = vals[i]
+	}
+	return &bound
+}
@@ -0,0 +1,50 @@
+package schema
+
+// Predicate is a join predicate between two relations added with
+// AddPredicate.
+type Predicate struct {
+	X, Y        RelationID
+	Key         JoinKey
+	Selectivity Selectivity
+	// err is the error bound of Selectivity, or 0 if it is exact.
+	err float64
+}
+
+// lastPredicate returns the index of the last predicate added between x and
+// y, recording a problem if there isn't one.
+func (b *Builder) lastPredicate(x, y RelationID) (int, bool) {
+	if !b.validRelation(x) || !b.validRelation(y) {
+		return 0, false
+	}
+	preds := b.edges[pair(x, y)]
+	if len(preds) == 0 {
+		b.problemf(UnknownPredicate, "no predicate between %s and %s", b.relation(x).Name, b.relation(y).Name)
+		return 0, false
+	}
+	return preds[len(preds)-1], true
+}
+
+// Predicates returns the predicates added with AddPredicate between a and b,
+// in the order they were added.
+func (s *Schema) Predicates(a, b RelationID) []Predicate {
+	var preds []Predicate
+	for _, i := range s.edges[pair(a, b)] {
+		p := s.predicates[i]
+		p.Key = s.canonicalKey(p.Key)
+		preds = append(preds, p)
+	}
+	return preds
+}
+
+// JoinPredicates returns the predicates added with AddPredicate which are
+// applied by a join of a and b.
+func (s *Schema) JoinPredicates(a, b RelSet) []Predicate {
+	var result []Predicate
+	for i, ok := a.Next(0); ok; i, ok = a.Next(i + 1) {
+		adj := s.neighbours[i-1].Intersection(b)
+		for j, ok := adj.Next(0); ok; j, ok = adj.Next(j + 1) {
+			result = append(result, s.Predicates(RelationID(i), RelationID(j))...)
+		}
+	}
+	return result
+}
@@ -2,24 +2,38 @@ package schema
 
 // RelSetMap maps RelSets to integers.
 type RelSetMap struct {
-	m map[uint64]int
+	m map[string]int
 }
 
 func NewRelSetMap() *RelSetMap {
 	return &RelSetMap{
-		m: make(map[uint64]int),
+		m: make(map[string]int),
 	}
 }
 
-func index(s RelSet) uint64 {
-	var idx uint64
+// index returns a key identifying s, however large the IDs of its relations
+// are: the bits of s, eight bytes at a time, up to the word holding its
+// largest relation.
+func index(s RelSet) string {
+	var buf []byte
+	var word uint64
+	base := 0
 	for i, ok := s.Next(0); ok; i, ok = s.Next(i + 1) {
-		if i > 63 {
-			panic("relset too big")
+		for i >= base+64 {
+			buf = appendWord(buf, word)
+			word = 0
+			base += 64
 		}
-		idx += 1 << uint64(i-1)
+		word |= 1 << uint(i-base)
 	}
-	return idx
+	return string(appendWord(buf, word))
+}
+
+func appendWord(buf []byte, w uint64) []byte {
+	for i := 0; i < 8; i++ {
+		buf = append(buf, byte(w>>(8*uint(i))))
+	}
+	return buf
 }
 
 func (m *RelSetMap) Set(s RelSet, i int) {
@@ -2,6 +2,7 @@ package schema
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/justinj/joinorder/util"
 )
@@ -11,6 +12,12 @@ type RelationID int
 type Selectivity float64
 type Cardinality float64
 
+// JoinKey identifies the values compared by a join predicate. Predicates which
+// share a JoinKey compare the same underlying values, so rows sorted on the key
+// of one of them are also sorted for the others. The zero JoinKey means no key
+// at all.
+type JoinKey int
+
 type RelSet = util.FastIntSet
 
 func S(args ...RelationID) RelSet {
@@ -25,6 +32,13 @@ type Relation struct {
 	Name RelationName
 	id   RelationID
 	card Cardinality
+	dist Distribution
+	// filters are applied to the card rows of the relation as it is scanned.
+	filters []Filter
+	// key is the primary key of the relation, if it has one.
+	key []ColumnID
+	// cardErr is the error bound of card, or 0 if it is exact.
+	cardErr float64
 }
 
 func pair(x, y RelationID) int {
@@ -36,26 +50,40 @@ func pair(x, y RelationID) int {
 }
 
 type Builder struct {
-	relations     []Relation
-	selectivities []Selectivity
-	nameToIdx     map[RelationName]int
+	relations   []Relation
+	predicates  []Predicate
+	edges       map[int][]int
+	numKeys     int
+	orderBy     JoinKey
+	parameters  []Parameter
+	overrides   map[string]Cardinality
+	columns     []Column
+	equalities  []equality
+	joints      []joint
+	combiner    Combiner
+	foreignKeys []ForeignKey
+	outerJoins  []operator
+	groupBy     []ColumnID
+	problems    problemList
+	nameToIdx   map[RelationName]int
 }
 
 func NewBuilder() *Builder {
 	return &Builder{
+		edges:     make(map[int][]int),
+		overrides: make(map[string]Cardinality),
 		nameToIdx: make(map[RelationName]int),
 	}
 }
 
 func (b *Builder) AddRelation(name RelationName, card Cardinality) RelationID {
 	if _, ok := b.nameToIdx[name]; ok {
-		panic(fmt.Sprintf("duplicate relation name %s", name))
+		b.problemf(DuplicateName, "duplicate relation name %s", name)
+	} else {
+		b.nameToIdx[name] = len(b.relations)
 	}
-
-	b.nameToIdx[name] = len(b.relations)
-
-	for i := 0; i < len(b.relations); i++ {
-		b.selectivities = append(b.selectivities, -1)
+	if !(card > 0) {
+		b.problemf(InvalidCardinality, "cardinality %v of %s is not positive", card, name)
 	}
 
 	id := RelationID(len(b.relations) + 1)
@@ -76,23 +104,143 @@ func (b *Builder) relation(x RelationID) Relation {
 	return b.relations[x-1]
 }
 
-func (b *Builder) AddPredicate(x, y RelationID, sel Selectivity) {
-	b.selectivities[pair(x, y)] = sel
+// AddPredicate adds a join predicate between x and y on a new join key, and
+// returns that key.
+func (b *Builder) AddPredicate(x, y RelationID, sel Selectivity) JoinKey {
+	k := b.NewJoinKey()
+	b.AddPredicateOnKey(x, y, sel, k)
+	return k
+}
+
+// AddPredicateOnKey adds a join predicate between x and y which compares the
+// values identified by k. Any number of predicates can be added between the
+// same two relations.
+func (b *Builder) AddPredicateOnKey(x, y RelationID, sel Selectivity, k JoinKey) {
+	if !b.validRelation(x) || !b.validRelation(y) || !b.validSelectivity(sel) {
+		return
+	}
+	if x == y {
+		b.problemf(SelfJoin, "predicate between %s and itself", b.relation(x).Name)
+		return
+	}
+	b.edges[pair(x, y)] = append(b.edges[pair(x, y)], len(b.predicates))
+	b.predicates = append(b.predicates, Predicate{X: x, Y: y, Key: k, Selectivity: sel})
+}
+
+// NewJoinKey returns a JoinKey not used by any predicate.
+func (b *Builder) NewJoinKey() JoinKey {
+	b.numKeys++
+	return JoinKey(b.numKeys)
 }
 
+// SetOrderBy requires the output of the query to be sorted on k.
+func (b *Builder) SetOrderBy(k JoinKey) {
+	b.orderBy = k
+}
+
+// SetCardinality overrides the estimated cardinality of the join of rels, for
+// instance with the true cardinality observed by executing the query. If rels
+// is a single relation this replaces the cardinality of scanning it, filters
+// included.
 func (b *Builder) SetCardinality(rels RelSet, cardinality Cardinality) {
+	if rels.Empty() {
+		b.problemf(UnknownRelation, "can't set the cardinality of an empty set")
+		return
+	}
+	for i, ok := rels.Next(0); ok; i, ok = rels.Next(i + 1) {
+		if !b.validRelation(RelationID(i)) {
+			return
+		}
+	}
+	if !(cardinality >= 0) {
+		b.problemf(InvalidCardinality, "cardinality %v of %s is negative", cardinality, rels)
+		return
+	}
+	b.overrides[index(rels)] = cardinality
+}
+
+// Build returns the schema, or a *BuildError listing everything wrong with
+// it. The schema is a copy, which isn't affected by using b afterwards.
+func (b *Builder) Build() (*Schema, error) {
+	s := &Schema{
+		relations:   append([]Relation(nil), b.relations...),
+		predicates:  append([]Predicate(nil), b.predicates...),
+		edges:       make(map[int][]int, len(b.edges)),
+		parameters:  append([]Parameter(nil), b.parameters...),
+		overrides:   make(map[string]Cardinality, len(b.overrides)),
+		columns:     append([]Column(nil), b.columns...),
+		equalities:  append([]equality(nil), b.equalities...),
+		joints:      append([]joint(nil), b.joints...),
+		combiner:    b.combiner,
+		foreignKeys: append([]ForeignKey(nil), b.foreignKeys...),
+		groupBy:     append([]ColumnID(nil), b.groupBy...),
+	}
+	// The slices held by the copies, like the filters of a relation, are only
+	// ever appended to or replaced by b, so they can be shared as long as
+	// appending to them makes a copy.
+	for p, preds := range b.edges {
+		s.edges[p] = preds[:len(preds):len(preds)]
+	}
+	for k, c := range b.overrides {
+		s.overrides[k] = c
+	}
+	problems := append(problemList(nil), b.problems...)
+	s.classes, s.canonical = b.buildClasses(&problems)
+	s.buildNeighbours()
+	b.buildOperators(s, &problems)
+	for _, c := range b.groupBy {
+		if !s.Column(c).hasStats {
+			problems.addf(InvalidEstimate, "GROUP BY column %s has no statistics", s.columnName(c))
+		}
+	}
+
+	// Anything sorted or partitioned on a column is sorted or partitioned on
+	// every column equal to it.
+	s.orderBy = s.canonicalKey(b.orderBy)
+	for i := range s.relations {
+		s.relations[i].dist.Key = s.canonicalKey(s.relations[i].dist.Key)
+	}
+
+	if len(problems) > 0 {
+		return nil, &BuildError{Problems: problems}
+	}
+	return s, nil
 }
 
-func (b *Builder) Build() *Schema {
-	return &Schema{
-		relations:     b.relations,
-		selectivities: b.selectivities,
+// MustBuild is like Build, but panics if there is anything wrong with the
+// schema.
+func (b *Builder) MustBuild() *Schema {
+	s, err := b.Build()
+	if err != nil {
+		panic(err)
 	}
+	return s
 }
 
 type Schema struct {
-	relations     []Relation
-	selectivities []Selectivity
+	relations []Relation
+	// predicates are the predicates added with AddPredicate, and edges maps
+	// each pair of relations to the indexes of the predicates between them.
+	predicates []Predicate
+	edges      map[int][]int
+	// neighbours[i] is the set of relations adjacent to relation i+1.
+	neighbours  []RelSet
+	orderBy     JoinKey
+	parameters  []Parameter
+	overrides   map[string]Cardinality
+	columns     []Column
+	equalities  []equality
+	classes     []class
+	canonical   map[JoinKey]JoinKey
+	joints      []joint
+	combiner    Combiner
+	foreignKeys []ForeignKey
+	groupBy     []ColumnID
+	// operators are the joins of the query as written, if it has any outer
+	// joins, and pairOps maps each pair of adjacent relations to the index of
+	// the operator which joins them.
+	operators []operator
+	pairOps   map[int]int
 }
 
 func (s *Schema) Relation(x RelationID) Relation {
@@ -103,57 +251,266 @@ func (s *Schema) Relation(x RelationID) Relation {
 }
 
 func (s *Schema) Adjacent(a, b RelationID) bool {
-	return s.selectivities[pair(a, b)] != -1
+	return s.Neighbours(a).Contains(int(b))
+}
+
+// Neighbours returns the set of relations adjacent to r.
+func (s *Schema) Neighbours(r RelationID) RelSet {
+	s.Relation(r)
+	return s.neighbours[r-1]
 }
 
-// TODO: this could be faster: just check if b intersects with the
-// neighbourhood of a.
 func (s *Schema) SubgraphsAdjacent(a, b RelSet) bool {
 	for i, ok := a.Next(0); ok; i, ok = a.Next(i + 1) {
-		for j, ok := b.Next(0); ok; j, ok = b.Next(j + 1) {
-			if s.Adjacent(RelationID(i), RelationID(j)) {
-				return true
-			}
+		if s.neighbours[i-1].Intersects(b) {
+			return true
 		}
 	}
 	return false
 }
 
+// buildNeighbours computes the neighbours of each relation of s.
+func (s *Schema) buildNeighbours() {
+	s.neighbours = make([]RelSet, len(s.relations))
+	for _, p := range s.predicates {
+		s.neighbours[p.X-1].Add(int(p.Y))
+		s.neighbours[p.Y-1].Add(int(p.X))
+	}
+	for i := range s.classes {
+		rels := s.classes[i].rels
+		for r, ok := rels.Next(0); ok; r, ok = rels.Next(r + 1) {
+			s.neighbours[r-1].UnionWith(rels)
+			s.neighbours[r-1].Remove(r)
+		}
+	}
+}
+
 func (s *Schema) NumRels() int {
 	return len(s.relations)
 }
 
 func (s *Schema) Selectivity(a, b RelationID) Selectivity {
-	sel := s.selectivities[pair(a, b)]
-	if sel == -1 {
-		return 1
+	if s.correlated() {
+		return s.ComplexSelectivity(S(a), S(b))
+	}
+	sel := s.predicateSelectivity(a, b)
+	for i := range s.classes {
+		sel *= s.classes[i].selectivity(s, S(a, b))
+	}
+	return sel
+}
+
+// predicateSelectivity returns the combined selectivity of the predicates
+// added with AddPredicate between a and b, assuming they are independent, or
+// 1 if there aren't any.
+func (s *Schema) predicateSelectivity(a, b RelationID) Selectivity {
+	var sel Selectivity = 1
+	for _, i := range s.edges[pair(a, b)] {
+		sel *= s.predicates[i].Selectivity
 	}
 	return sel
 }
 
 // ComplexSelectivity computes the selectivity of joining a join of the two
 // sets of relations.
-// It is the product of all pairwise selectivities.
+// It is the product of all pairwise selectivities, except that the
+// equalities of an equivalence class are only counted as many times as are
+// needed to connect the relations which have a column in it.
+//
+// If the schema has a Combiner or joint selectivities, the selectivities
+// aren't independent and can't simply be multiplied. Instead it is the
+// selectivity of every predicate within a∪b divided by those of a and b,
+// which doesn't depend on the order the relations are joined in. A Combiner
+// can make that more than 1, if it credits the predicates within a and b with
+// less than their share, but joining a and b can never produce more rows than
+// their cross product, so it is at most 1.
 func (s *Schema) ComplexSelectivity(a, b RelSet) Selectivity {
+	if s.correlated() {
+		// If either side is already known to be empty so is the join.
+		d := s.conjunctionSelectivity(a) * s.conjunctionSelectivity(b)
+		if d == 0 {
+			return 1
+		}
+		return Selectivity(math.Min(float64(s.conjunctionSelectivity(a.Union(b))/d), 1))
+	}
+
 	var sel Selectivity = 1
 	for i, ok := a.Next(0); ok; i, ok = a.Next(i + 1) {
-		for j, ok := b.Next(0); ok; j, ok = b.Next(j + 1) {
-			sel *= s.Selectivity(RelationID(i), RelationID(j))
+		adj := s.neighbours[i-1].Intersection(b)
+		for j, ok := adj.Next(0); ok; j, ok = adj.Next(j + 1) {
+			sel *= s.predicateSelectivity(RelationID(i), RelationID(j))
+		}
+	}
+
+	for i := range s.classes {
+		c := &s.classes[i]
+		if c.rels.Intersects(a) && c.rels.Intersects(b) {
+			// If either side is already known to be empty so is the join.
+			if d := c.selectivity(s, a) * c.selectivity(s, b); d != 0 {
+				sel *= c.selectivity(s, a.Union(b)) / d
+			}
 		}
 	}
 
 	return sel
 }
 
+// PredicateKey returns the JoinKey of a predicate between a and b, or 0 if
+// they are not adjacent.
+func (s *Schema) PredicateKey(a, b RelationID) JoinKey {
+	for _, i := range s.edges[pair(a, b)] {
+		if k := s.predicates[i].Key; k != 0 {
+			return s.canonicalKey(k)
+		}
+	}
+	for i := range s.classes {
+		if s.classes[i].rels.Contains(int(a)) && s.classes[i].rels.Contains(int(b)) {
+			return s.classes[i].key
+		}
+	}
+	return 0
+}
+
+// JoinKeys returns the distinct JoinKeys of the predicates connecting a and b.
+// A merge join of a and b can be performed on any of them.
+func (s *Schema) JoinKeys(a, b RelSet) []JoinKey {
+	var result []JoinKey
+	add := func(k JoinKey) {
+		for _, p := range result {
+			if p == k {
+				return
+			}
+		}
+		result = append(result, k)
+	}
+	for i, ok := a.Next(0); ok; i, ok = a.Next(i + 1) {
+		adj := s.neighbours[i-1].Intersection(b)
+		for j, ok := adj.Next(0); ok; j, ok = adj.Next(j + 1) {
+			for _, p := range s.edges[pair(RelationID(i), RelationID(j))] {
+				if k := s.predicates[p].Key; k != 0 {
+					add(s.canonicalKey(k))
+				}
+			}
+		}
+	}
+	for i := range s.classes {
+		if s.classes[i].rels.Intersects(a) && s.classes[i].rels.Intersects(b) {
+			add(s.classes[i].key)
+		}
+	}
+	return result
+}
+
+// OrderBy returns the JoinKey the output of the query must be sorted on, or 0
+// if it may be in any order.
+func (s *Schema) OrderBy() JoinKey {
+	return s.orderBy
+}
+
+// Cardinality returns the number of rows produced by scanning a, after its
+// filters are applied.
 func (s *Schema) Cardinality(a RelationID) Cardinality {
-	return s.Relation(a).card
+	if c, ok := s.CardinalityOverride(S(a)); ok {
+		return c
+	}
+	return s.Relation(a).card * Cardinality(s.FilterSelectivity(a))
+}
+
+// CardinalityOverride returns the cardinality of the join of rels set with
+// SetCardinality, if there is one.
+func (s *Schema) CardinalityOverride(rels RelSet) (Cardinality, bool) {
+	if len(s.overrides) == 0 {
+		return 0, false
+	}
+	c, ok := s.overrides[index(rels)]
+	return c, ok
+}
+
+// JoinCardinality returns the estimated cardinality of joining a and b, whose
+// cardinalities are ca and cb. It is the cardinality set for their union with
+// SetCardinality if there is one, and otherwise assumes the predicates
+// between a and b are independent. It is never more than the cardinality of
+// a side the join is known not to expand, nor less than that of a side an
+// outer join preserves. A semi or anti join never produces more rows than its
+// left side.
+func (s *Schema) JoinCardinality(a, b RelSet, ca, cb Cardinality) Cardinality {
+	if c, ok := s.CardinalityOverride(a.Union(b)); ok {
+		return c
+	}
+	card := Cardinality(float64(ca) * float64(cb) * float64(s.ComplexSelectivity(a, b)))
+	// Fewer rows on either side can leave fewer distinct values to match.
+	card = minCardinality(Cardinality(float64(card)*s.distinctCountFactor(a, b, ca, cb)), ca*cb)
+	if len(s.foreignKeys) > 0 {
+		if s.NonExpanding(a, b) && card > ca {
+			card = ca
+		}
+		if s.NonExpanding(b, a) && card > cb {
+			card = cb
+		}
+	}
+	// An outer join produces at least one row for each row it preserves, and
+	// a semi join at most one. An anti join produces the rows a semi join
+	// wouldn't, but is never estimated to produce none of them, since a plan
+	// above an empty input would look free.
+	switch s.JoinKind(a, b) {
+	case LeftOuterJoin:
+		card = maxCardinality(card, ca)
+	case RightOuterJoin:
+		card = maxCardinality(card, cb)
+	case FullOuterJoin:
+		card = maxCardinality(card, maxCardinality(ca, cb))
+	case SemiJoin, AntiJoin:
+		o := s.joinOperator(a, b)
+		left := ca
+		if !o.ses.Intersection(o.left).SubsetOf(a) {
+			left = cb
+		}
+		card = minCardinality(card, left)
+		if o.kind == AntiJoin && left > 0 {
+			unmatched := math.Max(1-float64(card)/float64(left), minAntiJoinFraction)
+			card = maxCardinality(Cardinality(float64(left)*unmatched), 1)
+		}
+	}
+	return card
+}
+
+// minAntiJoinFraction is the smallest fraction of its left side an anti join
+// is estimated to produce, however many rows are expected to match.
+const minAntiJoinFraction = 0.01
+
+func maxCardinality(a, b Cardinality) Cardinality {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minCardinality(a, b Cardinality) Cardinality {
+	if a < b {
+		return a
+	}
+	return b
 }
 
 func (s *Schema) GetRelationByName(name RelationName) RelationID {
+	if r, ok := s.LookupRelation(name); ok {
+		return r
+	}
+	panic(fmt.Sprintf("no relation with name %s", name))
+}
+
+// LookupRelation returns the relation named name, and false if there isn't
+// one.
+func (s *Schema) LookupRelation(name RelationName) (RelationID, bool) {
 	for i := range s.relations {
 		if s.relations[i].Name == name {
-			return s.relations[i].id
+			return s.relations[i].id, true
 		}
 	}
-	panic(fmt.Sprintf("no relation with name %s", name))
+	return 0, false
+}
+
+// HasRelation returns whether r is a relation of s.
+func (s *Schema) HasRelation(r RelationID) bool {
+	return r >= 1 && int(r) <= len(s.relations)
 }
@@ -1,6 +1,13 @@
 package schema
 
-import "testing"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
 
 func TestSchema(t *testing.T) {
 	builder := NewBuilder()
@@ -12,7 +19,7 @@ func TestSchema(t *testing.T) {
 	builder.AddPredicate(a, b, 0.2)
 	builder.AddPredicate(b, c, 0.01)
 
-	s := builder.Build()
+	s := builder.MustBuild()
 
 	if s.Cardinality(a) != 100 {
 		t.Fatal("cardinality of a should be 100")
@@ -50,3 +57,1042 @@ func TestSchema(t *testing.T) {
 		t.Fatal("selectivity between a and c is wrong")
 	}
 }
+
+func TestJoinKeys(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 3)
+
+	x := builder.AddPredicate(a, b, 0.2)
+	builder.AddPredicateOnKey(b, c, 0.01, x)
+	y := builder.AddPredicate(a, c, 0.5)
+
+	s := builder.MustBuild()
+
+	if x == y {
+		t.Fatal("predicates should be on different keys")
+	}
+
+	if s.PredicateKey(b, c) != x {
+		t.Fatal("b and c should be joined on x")
+	}
+
+	if o := s.JoinKeys(S(a), S(b, c)); len(o) != 2 || o[0] != x || o[1] != y {
+		t.Fatalf("expected keys [%d %d], got %v", x, y, o)
+	}
+
+	if o := s.JoinKeys(S(a, c), S(b)); len(o) != 1 || o[0] != x {
+		t.Fatalf("expected keys [%d], got %v", x, o)
+	}
+}
+
+func TestBind(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 3)
+
+	builder.AddPredicate(a, b, 0.2)
+	builder.AddPredicate(b, c, 0.01)
+	builder.SetParameter(b, c, 0.001, 0.1)
+
+	s := builder.MustBuild()
+	bound := s.Bind([]Selectivity{0.05})
+
+	if bound.Selectivity(b, c) != 0.05 {
+		t.Fatal("selectivity between b and c should be bound to 0.05")
+	}
+
+	if bound.Selectivity(a, b) != 0.2 {
+		t.Fatal("selectivity between a and b should be unchanged")
+	}
+
+	if s.Selectivity(b, c) != 0.01 {
+		t.Fatal("binding should not modify the original schema")
+	}
+}
+
+func TestSample(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 1000)
+
+	builder.AddPredicate(a, b, 0.2)
+	builder.SetCardinalityError(a, 10)
+	builder.SetSelectivityError(a, b, 10)
+
+	s := builder.MustBuild()
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 100; i++ {
+		sample := s.Sample(rng)
+		if c := sample.Cardinality(a); c < 10 || c > 1000 {
+			t.Fatalf("cardinality of a should be within [10, 1000], got %v", c)
+		}
+		if c := sample.Cardinality(b); c != 1000 {
+			t.Fatalf("cardinality of b should be exact, got %v", c)
+		}
+		if sel := sample.Selectivity(a, b); sel < 0.02 || sel > 1 {
+			t.Fatalf("selectivity between a and b should be within [0.02, 1], got %v", sel)
+		}
+	}
+
+	if s.Cardinality(a) != 100 || s.Selectivity(a, b) != 0.2 {
+		t.Fatal("sampling should not modify the original schema")
+	}
+}
+
+func TestSetCardinality(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 3)
+
+	builder.AddPredicate(a, b, 0.2)
+	builder.AddPredicate(b, c, 0.01)
+
+	builder.SetCardinality(S(c), 30)
+	builder.SetCardinality(S(a, b), 5)
+
+	s := builder.MustBuild()
+
+	if s.Cardinality(c) != 30 {
+		t.Fatal("cardinality of c should be overridden")
+	}
+
+	if card := s.JoinCardinality(S(a), S(b), 100, 1000); card != 5 {
+		t.Fatalf("cardinality of a ⋈ b should be overridden, got %v", card)
+	}
+
+	if card := s.JoinCardinality(S(a, b), S(c), 5, 30); card != 1.5 {
+		t.Fatalf("cardinality of a ⋈ b ⋈ c should be estimated from a ⋈ b, got %v", card)
+	}
+
+	// Relations past the 64th are told apart from the ones 64 before them.
+	builder = NewBuilder()
+	for i := 0; i < 100; i++ {
+		builder.AddRelation(RelationName(fmt.Sprintf("R%d", i)), 1000)
+	}
+	builder.SetCardinality(S(70), 5)
+	builder.SetCardinality(S(6, 70), 7)
+	s = builder.MustBuild()
+	if s.Cardinality(70) != 5 || s.Cardinality(6) != 1000 {
+		t.Fatalf("expected only R69 to be overridden, got %v and %v", s.Cardinality(70), s.Cardinality(6))
+	}
+	if card, ok := s.CardinalityOverride(S(6, 70)); !ok || card != 7 {
+		t.Fatalf("expected an override of 7 for (6,70), got %v", card)
+	}
+}
+
+func TestEqualities(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 10)
+
+	ax := builder.AddColumn(a, "x")
+	bx := builder.AddColumn(b, "x")
+	cx := builder.AddColumn(c, "x")
+	cy := builder.AddColumn(c, "y")
+
+	// A.x = B.x AND B.x = C.x
+	builder.AddEquality(ax, bx, 0.01)
+	builder.AddEquality(bx, cx, 0.0001)
+
+	s := builder.MustBuild()
+
+	if !s.Adjacent(a, c) {
+		t.Fatal("a and c should be adjacent through A.x = C.x")
+	}
+
+	if s.Selectivity(a, b) != 0.01 || s.Selectivity(b, c) != 0.0001 {
+		t.Fatal("explicit equalities should keep their selectivities")
+	}
+
+	if sel := s.Selectivity(a, c); math.Abs(float64(sel)-0.001) > 1e-12 {
+		t.Fatalf("implied equality should have the geometric mean selectivity, got %v", sel)
+	}
+
+	// Whichever order the relations are joined in, the result should be the
+	// same size, and equal to that of applying the explicit equalities once.
+	expected := 100 * 1000 * 10 * 0.01 * 0.0001
+	for _, tc := range []struct{ first, second, third RelationID }{
+		{a, b, c},
+		{b, c, a},
+		{a, c, b},
+	} {
+		card := s.JoinCardinality(S(tc.first), S(tc.second), s.Cardinality(tc.first), s.Cardinality(tc.second))
+		card = s.JoinCardinality(S(tc.first, tc.second), S(tc.third), card, s.Cardinality(tc.third))
+		if math.Abs(float64(card)-expected) > 1e-9 {
+			t.Errorf("expected joining %d, %d then %d to produce %v rows, got %v", tc.first, tc.second, tc.third, expected, card)
+		}
+	}
+
+	if s.ColumnKey(ax) != s.ColumnKey(cx) || s.ColumnKey(ax) == s.ColumnKey(cy) {
+		t.Fatal("only equal columns should share a key")
+	}
+
+	if keys := s.JoinKeys(S(a), S(c)); len(keys) != 1 || keys[0] != s.ColumnKey(ax) {
+		t.Fatalf("a and c should be joinable on x, got keys %v", keys)
+	}
+
+	if cols := s.EquivalentColumns(bx); len(cols) != 3 {
+		t.Fatalf("expected 3 equivalent columns, got %v", cols)
+	}
+}
+
+func TestColumnStats(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 1000)
+	c := builder.AddRelation("C", 10000)
+
+	ax := builder.AddColumn(a, "x")
+	bx := builder.AddColumn(b, "x")
+	cx := builder.AddColumn(c, "x")
+	builder.SetColumnStats(ax, ColumnStats{DistinctCount: 10})
+	builder.SetColumnStats(bx, ColumnStats{DistinctCount: 100, NullFraction: 0.5})
+	builder.SetColumnStats(cx, ColumnStats{DistinctCount: 1000})
+
+	builder.AddEstimatedEquality(ax, bx)
+	builder.AddEstimatedEquality(bx, cx)
+
+	s := builder.MustBuild()
+
+	if sel := s.Selectivity(a, b); math.Abs(float64(sel)-0.005) > 1e-12 {
+		t.Fatalf("expected 1/max(ndv) of the non-null rows, got %v", sel)
+	}
+
+	// Every column but the one with the fewest distinct values contributes
+	// 1/ndv, however the relations are joined.
+	expected := 100 * 1000 * 10000 * 0.5 / (100 * 1000)
+	card := s.JoinCardinality(S(a), S(c), s.Cardinality(a), s.Cardinality(c))
+	card = s.JoinCardinality(S(a, c), S(b), card, s.Cardinality(b))
+	if math.Abs(float64(card)-expected) > 1e-6 {
+		t.Fatalf("expected %v rows, got %v", expected, card)
+	}
+
+	if ndv := s.DistinctCount(cx, S(b, c), 1e9); ndv != 100 {
+		t.Fatalf("expected the join to keep at most 100 distinct values, got %v", ndv)
+	}
+	if ndv := s.DistinctCount(cx, S(c), 50); ndv != 50 {
+		t.Fatalf("expected the distinct count to be capped by the rows, got %v", ndv)
+	}
+
+	// If only 20 rows of C are left, they have at most 20 distinct values,
+	// each of which matches one of the 10 rows left of A.
+	if card := s.JoinCardinality(S(a), S(c), 10, 20); math.Abs(float64(card)-10) > 1e-9 {
+		t.Fatalf("expected 10 rows once the distinct counts are capped, got %v", card)
+	}
+
+	builder = NewBuilder()
+	d := builder.AddRelation("D", 100)
+	e := builder.AddRelation("E", 100)
+	dx := builder.AddColumn(d, "x")
+	ex := builder.AddColumn(e, "x")
+	builder.SetColumnStats(dx, ColumnStats{DistinctCount: 100, Min: 0, Max: 100})
+	builder.SetColumnStats(ex, ColumnStats{DistinctCount: 100, Min: 50, Max: 150})
+	builder.AddEstimatedEquality(dx, ex)
+	if sel := builder.MustBuild().Selectivity(d, e); math.Abs(float64(sel)-0.005) > 1e-12 {
+		t.Fatalf("expected only the overlapping halves of the ranges to match, got %v", sel)
+	}
+
+	// Even columns whose ranges don't overlap are expected to match once.
+	builder = NewBuilder()
+	d = builder.AddRelation("D", 100)
+	e = builder.AddRelation("E", 100)
+	dx = builder.AddColumn(d, "x")
+	ex = builder.AddColumn(e, "x")
+	builder.SetColumnStats(dx, ColumnStats{DistinctCount: 10, Min: 0, Max: 10})
+	builder.SetColumnStats(ex, ColumnStats{DistinctCount: 10, Min: 20, Max: 30})
+	builder.AddEstimatedEquality(dx, ex)
+	s = builder.MustBuild()
+	if card := s.JoinCardinality(S(d), S(e), 100, 100); math.Abs(float64(card)-1) > 1e-9 {
+		t.Fatalf("expected one matching row, got %v", card)
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	// Half the rows of each column are 0, and the rest are 1 to 50.
+	var values []float64
+	for i := 1; i <= 50; i++ {
+		values = append(values, 0, float64(i))
+	}
+
+	for _, tc := range []struct {
+		name     string
+		hist     Histogram
+		expected float64
+	}{
+		{"end-biased", NewEndBiasedHistogram(values, 1), (50*50 + 50) / (100.0 * 100)},
+		{"equi-depth", NewEquiDepthHistogram(values, 10), (50*50 + 50) / (100.0 * 100)},
+	} {
+		builder := NewBuilder()
+		a := builder.AddRelation("A", 100)
+		b := builder.AddRelation("B", 100)
+		ax := builder.AddColumn(a, "x")
+		bx := builder.AddColumn(b, "x")
+		builder.SetHistogram(ax, tc.hist)
+		builder.SetHistogram(bx, tc.hist)
+		builder.AddEquality(ax, bx, 0.5)
+
+		sel := builder.MustBuild().Selectivity(a, b)
+		if math.Abs(float64(sel)-tc.expected) > 1e-9 {
+			t.Errorf("%s: expected selectivity %v, got %v", tc.name, tc.expected, sel)
+		}
+	}
+
+	// A histogram of no rows can't be used to estimate anything.
+	builder := NewBuilder()
+	a := builder.AddRelation("A", 100)
+	builder.SetHistogram(builder.AddColumn(a, "x"), Histogram{})
+	if _, err := builder.Build(); err == nil {
+		t.Error("expected an error for an empty histogram")
+	}
+
+	// Without the histograms the skew is lost.
+	builder = NewBuilder()
+	a = builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 100)
+	ax := builder.AddColumn(a, "x")
+	bx := builder.AddColumn(b, "x")
+	builder.SetColumnStats(ax, ColumnStats{DistinctCount: 51})
+	builder.SetColumnStats(bx, ColumnStats{DistinctCount: 51})
+	builder.AddEstimatedEquality(ax, bx)
+	if sel := builder.MustBuild().Selectivity(a, b); math.Abs(float64(sel)-1.0/51) > 1e-9 {
+		t.Errorf("expected selectivity 1/51, got %v", sel)
+	}
+}
+
+func TestCorrelatedSelectivity(t *testing.T) {
+	build := func(joint bool, c Combiner) (*Schema, [3]RelationID) {
+		builder := NewBuilder()
+		a := builder.AddRelation("A", 1000)
+		b := builder.AddRelation("B", 1000)
+		c2 := builder.AddRelation("C", 1000)
+		ax, ay := builder.AddColumn(a, "x"), builder.AddColumn(a, "y")
+		bx, by := builder.AddColumn(b, "x"), builder.AddColumn(b, "y")
+		cz := builder.AddColumn(c2, "z")
+
+		// A.x = B.x AND A.y = B.y AND B.x = C.z
+		ex := builder.AddEquality(ax, bx, 0.01)
+		ey := builder.AddEquality(ay, by, 0.01)
+		builder.AddEquality(bx, cz, 0.1)
+		if joint {
+			builder.SetJointSelectivity(0.01, ex, ey)
+		}
+		if c != nil {
+			builder.SetCombiner(c)
+		}
+		return builder.MustBuild(), [3]RelationID{a, b, c2}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		joint    bool
+		combiner Combiner
+		ab, abc  float64
+	}{
+		{"independence", false, nil, 0.0001, 0.00001},
+		{"joint", true, nil, 0.01, 0.001},
+		{"backoff", false, ExponentialBackoff{}, 0.001, 0.01 * math.Pow(0.01, 0.5) * math.Pow(0.1, 0.25)},
+		{"minimum", false, Minimum{}, 0.01, 0.01},
+	} {
+		s, r := build(tc.joint, tc.combiner)
+		a, b, c := r[0], r[1], r[2]
+		if sel := s.Selectivity(a, b); math.Abs(float64(sel)-tc.ab)/tc.ab > 1e-9 {
+			t.Errorf("%s: expected selectivity %v between A and B, got %v", tc.name, tc.ab, sel)
+		}
+
+		// The result doesn't depend on the join order.
+		for _, order := range [][3]RelationID{{a, b, c}, {b, c, a}, {a, c, b}} {
+			sel := s.ComplexSelectivity(S(order[0]), S(order[1])) *
+				s.ComplexSelectivity(S(order[0], order[1]), S(order[2]))
+			if math.Abs(float64(sel)-tc.abc)/tc.abc > 1e-9 {
+				t.Errorf("%s: expected selectivity %v joining %v, got %v", tc.name, tc.abc, order, sel)
+			}
+		}
+	}
+}
+
+func TestCorrelatedSelectivityOfJoins(t *testing.T) {
+	// A1 and A2, and B1 and B2, are joined to each other before A1 is joined
+	// to B1.
+	for _, tc := range []struct {
+		name     string
+		combiner Combiner
+		expected Selectivity
+	}{
+		{"independence", Independence{}, 0.1},
+		// Both count A1 = B1 for less than the predicates already applied
+		// within A and within B, but it can't add rows.
+		{"backoff", ExponentialBackoff{}, 1},
+		{"minimum", Minimum{}, 1},
+	} {
+		builder := NewBuilder()
+		a1 := builder.AddRelation("A1", 100)
+		a2 := builder.AddRelation("A2", 100)
+		b1 := builder.AddRelation("B1", 100)
+		b2 := builder.AddRelation("B2", 100)
+		builder.AddPredicate(a1, a2, 0.1)
+		builder.AddPredicate(b1, b2, 0.1)
+		builder.AddPredicate(a1, b1, 0.1)
+		builder.SetCombiner(tc.combiner)
+		s := builder.MustBuild()
+
+		if sel := s.ComplexSelectivity(S(a1, a2), S(b1, b2)); math.Abs(float64(sel-tc.expected)) > 1e-9 {
+			t.Errorf("%s: expected selectivity %v, got %v", tc.name, tc.expected, sel)
+		}
+	}
+}
+
+func TestMultiplePredicates(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 100)
+
+	// A.x = B.x AND A.y = B.y
+	kx := builder.AddPredicate(a, b, 0.1)
+	ky := builder.AddPredicate(a, b, 0.01)
+	builder.SetParameter(a, b, 0.001, 0.1)
+
+	s := builder.MustBuild()
+
+	if sel := s.Selectivity(a, b); math.Abs(float64(sel)-0.001) > 1e-12 {
+		t.Fatalf("expected both predicates to be applied, got %v", sel)
+	}
+
+	preds := s.Predicates(b, a)
+	if len(preds) != 2 || preds[0].Key != kx || preds[1].Key != ky {
+		t.Fatalf("expected predicates on %d and %d, got %v", kx, ky, preds)
+	}
+
+	if keys := s.JoinKeys(S(a), S(b)); len(keys) != 2 {
+		t.Fatalf("expected a and b to be joinable on either key, got %v", keys)
+	}
+
+	// The parameter replaces only the predicate it was set on.
+	if sel := s.Bind([]Selectivity{0.1}).Selectivity(a, b); math.Abs(float64(sel)-0.01) > 1e-12 {
+		t.Fatalf("expected bound selectivity of 0.01, got %v", sel)
+	}
+
+	builder.SetCombiner(Minimum{})
+	if sel := builder.MustBuild().Selectivity(a, b); sel != 0.01 {
+		t.Fatalf("expected the predicates to be combined with the minimum, got %v", sel)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	builder := NewBuilder()
+
+	f := builder.AddRelation("F", 1000000)
+	d := builder.AddRelation("D", 100)
+	e := builder.AddRelation("E", 10)
+	g := builder.AddRelation("G", 1000)
+
+	fd := builder.AddColumn(f, "d_id")
+	dID := builder.AddColumn(d, "id")
+	de := builder.AddColumn(d, "e_id")
+	eID := builder.AddColumn(e, "id")
+	fg := builder.AddColumn(f, "g_id")
+	gID := builder.AddColumn(g, "id")
+
+	builder.SetPrimaryKey(d, dID)
+	builder.SetPrimaryKey(e, eID)
+	builder.SetPrimaryKey(g, gID)
+	builder.AddForeignKey(d, fd)
+	builder.AddForeignKey(e, de)
+	builder.AddForeignKey(g, fg)
+
+	builder.AddEstimatedEquality(fd, dID)
+	builder.AddEstimatedEquality(de, eID)
+	// A hand-tuned selectivity which is too high.
+	builder.AddEquality(fg, gID, 0.1)
+	builder.AddFilter(d, "d.region = 'EU'", 0.1)
+
+	s := builder.MustBuild()
+
+	if sel := s.Selectivity(f, d); math.Abs(float64(sel)-0.01) > 1e-12 {
+		t.Fatalf("expected selectivity 1/|D|, got %v", sel)
+	}
+
+	// Only the fact rows which match the filtered dimension remain.
+	if card := s.JoinCardinality(S(f), S(d), s.Cardinality(f), s.Cardinality(d)); math.Abs(float64(card)-100000) > 1e-6 {
+		t.Fatalf("expected 100000 rows, got %v", card)
+	}
+
+	if !s.NonExpanding(S(f), S(d, e)) || s.NonExpanding(S(d), S(f)) || s.NonExpanding(S(f), S(d, g)) {
+		t.Fatal("only joins of F to its dimensions should be non-expanding")
+	}
+
+	if card := s.JoinCardinality(S(f), S(g), s.Cardinality(f), s.Cardinality(g)); card != 1000000 {
+		t.Fatalf("expected the join to be capped at the rows of F, got %v", card)
+	}
+}
+
+func TestLargeSchema(t *testing.T) {
+	const n = 5000
+	builder := NewBuilder()
+	for i := 1; i <= n; i++ {
+		r := builder.AddRelation(RelationName(fmt.Sprintf("R%d", i)), 100)
+		if i > 1 {
+			builder.AddPredicate(r-1, r, 0.01)
+		}
+	}
+	s := builder.MustBuild()
+
+	if nb := s.Neighbours(n / 2); !nb.Equals(S(n/2-1, n/2+1)) {
+		t.Fatalf("expected the neighbours of R%d to be its predecessor and successor, got %s", n/2, nb)
+	}
+	if !s.SubgraphsAdjacent(S(1, 2, 3), S(4, n)) || s.SubgraphsAdjacent(S(1, 2), S(4, n)) {
+		t.Fatal("only sets containing consecutive relations should be adjacent")
+	}
+	if sel := s.ComplexSelectivity(S(1, 2), S(3, n)); math.Abs(float64(sel)-0.01) > 1e-12 {
+		t.Fatalf("expected selectivity 0.01, got %v", sel)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	builder := NewBuilder()
+
+	// A - B - C - D, and E on its own.
+	a := builder.AddRelation("A", 10)
+	b := builder.AddRelation("B", 10)
+	c := builder.AddRelation("C", 10)
+	d := builder.AddRelation("D", 10)
+	e := builder.AddRelation("E", 10)
+	builder.AddPredicate(a, b, 0.1)
+	builder.AddPredicate(b, c, 0.1)
+	builder.AddPredicate(c, d, 0.1)
+
+	s := builder.MustBuild()
+
+	if n := s.Neighbourhood(S(b, c)); !n.Equals(S(a, d)) {
+		t.Fatalf("expected N({B, C}) = {A, D}, got %s", n)
+	}
+
+	for _, tc := range []struct {
+		set       RelSet
+		connected bool
+	}{
+		{S(a), true},
+		{S(a, b, c), true},
+		{S(a, c), false},
+		{S(c, d, e), false},
+		{RelSet{}, false},
+	} {
+		if s.Connected(tc.set) != tc.connected {
+			t.Errorf("expected Connected(%s) = %v", tc.set, tc.connected)
+		}
+	}
+
+	// A chain of n relations has n(n+1)/2 connected subsets.
+	seen := make(map[string]bool)
+	s.ForEachConnectedSubset(S(a, b, c, d, e), func(sub RelSet) {
+		if seen[sub.String()] {
+			t.Errorf("%s was produced twice", sub)
+		}
+		if !s.Connected(sub) {
+			t.Errorf("%s is not connected", sub)
+		}
+		seen[sub.String()] = true
+	})
+	if len(seen) != 4*5/2+1 {
+		t.Fatalf("expected 11 connected subsets, got %d", len(seen))
+	}
+}
+
+func TestBuildCopies(t *testing.T) {
+	builder := NewBuilder()
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 100)
+	c := builder.AddRelation("C", 100)
+	builder.AddPredicate(a, b, 0.1)
+	builder.AddPredicate(b, c, 0.1)
+	ax := builder.AddColumn(a, "x")
+	s := builder.MustBuild()
+
+	// Nothing done with the builder afterwards affects s.
+	builder.AddPredicate(b, c, 0.5)
+	builder.SetSelectivityError(a, b, 10)
+	builder.SetCardinality(S(a, b), 5)
+	builder.SetColumnStats(ax, ColumnStats{DistinctCount: 10})
+	if preds := s.Predicates(b, c); len(preds) != 1 {
+		t.Errorf("expected one predicate between B and C, got %v", preds)
+	}
+	if q := s.SelectivityError(a, b); q != 1 {
+		t.Errorf("expected no selectivity error between A and B, got %v", q)
+	}
+	if card := s.JoinCardinality(S(a), S(b), 100, 100); card != 1000 {
+		t.Errorf("expected a cardinality of 1000 for A and B, got %v", card)
+	}
+	if _, ok := s.ColumnStats(ax); ok {
+		t.Error("expected A.x to have no statistics")
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 100)
+	b := builder.AddRelation("B", 0)
+	builder.AddRelation("A", 10)
+
+	builder.AddPredicate(a, 7, 0.1)
+	builder.AddPredicate(a, a, 0.1)
+	builder.AddPredicate(a, b, 1.5)
+	builder.AddPredicate(a, b, 0)
+	builder.AddFilter(b, "b.x = 1", 0.5)
+
+	_, err := builder.Build()
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Fatalf("expected a *BuildError, got %v", err)
+	}
+
+	expected := []ProblemKind{
+		InvalidCardinality,
+		DuplicateName,
+		UnknownRelation,
+		SelfJoin,
+		InvalidSelectivity,
+		InvalidSelectivity,
+	}
+	if len(buildErr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), err)
+	}
+	for i, p := range buildErr.Problems {
+		if p.Kind != expected[i] {
+			t.Errorf("expected problem %d to be a %s, got %s", i, expected[i], p)
+		}
+	}
+
+	builder = NewBuilder()
+	builder.AddRelation("A", 100)
+	if _, err := builder.Build(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	builder := NewBuilder()
+
+	a := builder.AddRelation("A", 1000)
+	b := builder.AddRelation("B", 100)
+	c := builder.AddRelation("C", 10)
+
+	k := builder.AddPredicate(a, b, 0.01)
+	builder.AddPredicateOnKey(b, c, 0.1, k)
+	builder.AddFilter(a, "a.y > 5", 0.5)
+	ax := builder.AddColumn(a, "x")
+	cx := builder.AddColumn(c, "x")
+	builder.SetColumnStats(ax, ColumnStats{DistinctCount: 100})
+	builder.SetColumnStats(cx, ColumnStats{DistinctCount: 10})
+	builder.AddEstimatedEquality(ax, cx)
+
+	// D, E and F are joined on a class of columns with nulls, where D and F
+	// are only implied to be equal.
+	d := builder.AddRelation("D", 1000)
+	e := builder.AddRelation("E", 100)
+	f := builder.AddRelation("F", 10)
+	dy := builder.AddColumn(d, "y")
+	ey := builder.AddColumn(e, "y")
+	fy := builder.AddColumn(f, "y")
+	builder.SetColumnStats(dy, ColumnStats{DistinctCount: 1000, NullFraction: 0.5})
+	builder.SetColumnStats(ey, ColumnStats{DistinctCount: 100})
+	builder.SetColumnStats(fy, ColumnStats{DistinctCount: 10, NullFraction: 0.2, Min: 1, Max: 10})
+	builder.AddEstimatedEquality(dy, ey)
+	builder.AddEquality(ey, fy, 0.05)
+
+	s := builder.MustBuild()
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, set := range []RelSet{S(a, b), S(b, c), S(a, c), S(d, e), S(e, f), S(d, f)} {
+		l, r := RelationID(set.Ordered()[0]), RelationID(set.Ordered()[1])
+		if s.Selectivity(l, r) != loaded.Selectivity(l, r) {
+			t.Errorf("expected selectivity %v between %d and %d, got %v", s.Selectivity(l, r), l, r, loaded.Selectivity(l, r))
+		}
+	}
+	if sel, expected := loaded.ComplexSelectivity(S(d, e), S(f)), s.ComplexSelectivity(S(d, e), S(f)); sel != expected {
+		t.Errorf("expected selectivity %v joining F to D and E, got %v", expected, sel)
+	}
+	if stats, _ := loaded.ColumnStats(fy); stats != (ColumnStats{DistinctCount: 10, NullFraction: 0.2, Min: 1, Max: 10}) {
+		t.Errorf("expected the statistics of F.y to be kept, got %+v", stats)
+	}
+	if loaded.Cardinality(a) != 500 {
+		t.Errorf("expected the filter on A to be kept, got cardinality %v", loaded.Cardinality(a))
+	}
+	if keys := loaded.JoinKeys(S(a, c), S(b)); len(keys) != 1 {
+		t.Errorf("expected the predicates to still share a key, got %v", keys)
+	}
+
+	// Anything which changes the query itself can't be encoded.
+	for _, unencodable := range []string{"left outer join", "anti join", "ORDER BY", "GROUP BY"} {
+		builder := NewBuilder()
+		x := builder.AddRelation("A", 1000)
+		y := builder.AddRelation("B", 100)
+		k := builder.AddPredicate(x, y, 0.01)
+		g := builder.AddColumn(x, "g")
+		builder.SetColumnStats(g, ColumnStats{DistinctCount: 10})
+		switch unencodable {
+		case "left outer join":
+			builder.AddOuterJoin(LeftOuterJoin, S(x), S(y))
+		case "anti join":
+			builder.AddAntiJoin(S(x), S(y))
+		case "ORDER BY":
+			builder.SetOrderBy(k)
+		case "GROUP BY":
+			builder.SetGroupBy(g)
+		}
+		if _, err := json.Marshal(builder.MustBuild()); err == nil || !strings.Contains(err.Error(), unencodable) {
+			t.Errorf("expected an error encoding a %s, got %v", unencodable, err)
+		}
+	}
+
+	_, err = LoadJSON([]byte(`{
+		"relations": [{"name": "A", "cardinality": 10, "columns": ["x"]}],
+		"predicates": [{"left": "A", "right": "B", "selectivity": 0.1}],
+		"equalities": [{"left": "A.x", "right": "x"}]
+	}`))
+	if buildErr, ok := err.(*BuildError); !ok || len(buildErr.Problems) != 2 {
+		t.Fatalf("expected two problems, got %v", err)
+	}
+}
+
+func TestAnalyzeShape(t *testing.T) {
+	build := func(n int, edges [][2]int) *Schema {
+		builder := NewBuilder()
+		for i := 1; i <= n; i++ {
+			builder.AddRelation(RelationName(fmt.Sprintf("R%d", i)), 10)
+		}
+		for _, e := range edges {
+			builder.AddPredicate(RelationID(e[0]), RelationID(e[1]), 0.1)
+		}
+		return builder.MustBuild()
+	}
+
+	var clique [][2]int
+	for i := 1; i <= 5; i++ {
+		for j := i + 1; j <= 5; j++ {
+			clique = append(clique, [2]int{i, j})
+		}
+	}
+
+	// The counts for n = 5 are those given by Moerkotte and Neumann.
+	for _, tc := range []struct {
+		n          int
+		edges      [][2]int
+		shape      Shape
+		csg, ccp   int
+		components int
+	}{
+		{5, [][2]int{{1, 2}, {2, 3}, {3, 4}, {4, 5}}, Chain, 15, 20, 1},
+		{5, [][2]int{{1, 2}, {1, 3}, {1, 4}, {1, 5}}, Star, 20, 32, 1},
+		{5, [][2]int{{1, 2}, {2, 3}, {3, 4}, {4, 5}, {5, 1}}, Cycle, 21, 40, 1},
+		{5, clique, Clique, 31, 90, 1},
+		{6, [][2]int{{1, 2}, {1, 3}, {1, 4}, {2, 5}, {3, 6}}, Snowflake, 0, 0, 1},
+		{6, [][2]int{{1, 2}, {2, 3}, {3, 4}, {2, 5}, {4, 6}}, Snowflake, 0, 0, 1},
+		{7, [][2]int{{1, 2}, {2, 3}, {3, 4}, {4, 5}, {5, 6}, {3, 7}}, Tree, 0, 0, 1},
+		{4, [][2]int{{1, 2}, {3, 4}}, General, 0, 0, 2},
+	} {
+		r := build(tc.n, tc.edges).AnalyzeShape()
+		if r.Shape != tc.shape || len(r.Components) != tc.components {
+			t.Errorf("expected a %s with %d components, got\n%s", tc.shape, tc.components, r)
+		}
+		if tc.csg != 0 && (r.ConnectedSubgraphs != tc.csg || r.CsgCmpPairs != tc.ccp) {
+			t.Errorf("%s: expected %d connected subgraphs and %d csg-cmp pairs, got\n%s", tc.shape, tc.csg, tc.ccp, r)
+		}
+	}
+
+	// The formulas agree with counting one at a time.
+	for n := 4; n <= 8; n++ {
+		var chain, star, cycle, complete [][2]int
+		for i := 1; i < n; i++ {
+			chain = append(chain, [2]int{i, i + 1})
+			star = append(star, [2]int{1, i + 1})
+			for j := i + 1; j <= n; j++ {
+				complete = append(complete, [2]int{i, j})
+			}
+		}
+		cycle = append(chain[:n-1:n-1], [2]int{n, 1})
+		for _, edges := range [][][2]int{chain, star, cycle, complete} {
+			s := build(n, edges)
+			r := s.AnalyzeShape()
+			var all RelSet
+			all.AddRange(1, n)
+			csg, ccp, _ := s.countSubgraphs(General, all)
+			if r.ConnectedSubgraphs != csg || r.CsgCmpPairs != ccp {
+				t.Errorf("expected %d connected subgraphs and %d csg-cmp pairs, got\n%s", csg, ccp, r)
+			}
+		}
+	}
+
+	// A star of 64 dimensions has more subgraphs than fit in an int, and a
+	// snowflake of them too many to count.
+	var star [][2]int
+	for i := 2; i <= 65; i++ {
+		star = append(star, [2]int{1, i})
+	}
+	r := build(65, star).AnalyzeShape()
+	if r.Shape != Star || !r.Truncated || r.ConnectedSubgraphs != int(^uint(0)>>1) {
+		t.Errorf("expected a truncated star, got\n%s", r)
+	}
+	r = build(66, append(star, [2]int{2, 66})).AnalyzeShape()
+	if r.Shape != Snowflake || !r.Truncated || r.ConnectedSubgraphs != maxEnumeratedCount || r.CsgCmpPairs != maxEnumeratedCount {
+		t.Errorf("expected a truncated snowflake, got\n%s", r)
+	}
+
+	r = build(5, append(clique[:0:0], [2]int{1, 2}, [2]int{2, 3}, [2]int{3, 1}, [2]int{3, 4}, [2]int{4, 5})).AnalyzeShape()
+	expected := `shape: general
+components: 1
+edges: 5
+cycles: 1
+degrees: 1×1 3×2 1×3
+connected subgraphs: 18
+csg-cmp pairs: 29
+`
+	if r.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, r)
+	}
+}
+
+func TestDOT(t *testing.T) {
+	b := NewBuilder()
+	x := b.AddRelation("A", 100)
+	y := b.AddRelation("B", 10)
+	z := b.AddRelation("C", 1000)
+	b.AddPredicate(x, y, 0.1)
+	b.AddPredicate(y, z, 0.01)
+	b.AddFilter(z, "c.n > 5", 0.5)
+
+	expected := `graph G {
+  r1 [label="A\n100"];
+  r2 [label="B\n10"];
+  r3 [label="C\n500"];
+  r1 -- r2 [label="0.1"];
+  r2 -- r3 [label="0.01"];
+}
+`
+	if actual := b.MustBuild().DOT(); actual != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestOuterJoins(t *testing.T) {
+	// A ⟕ (B ⋈ C): the outer join can only be applied once B and C are
+	// joined.
+	b := NewBuilder()
+	x := b.AddRelation("A", 100)
+	y := b.AddRelation("B", 10)
+	z := b.AddRelation("C", 10)
+	b.AddPredicate(x, y, 0.001)
+	b.AddPredicate(y, z, 0.1)
+	b.AddOuterJoin(LeftOuterJoin, S(x), S(y, z))
+	s := b.MustBuild()
+
+	if s.CanJoin(S(x), S(y)) || !s.CanJoin(S(y), S(z)) || !s.CanJoin(S(x), S(y, z)) {
+		t.Fatal("wrong joins allowed for A ⟕ (B ⋈ C)")
+	}
+	if s.JoinKind(S(x), S(y, z)) != LeftOuterJoin || s.JoinKind(S(y, z), S(x)) != RightOuterJoin ||
+		s.JoinKind(S(y), S(z)) != InnerJoin {
+		t.Fatal("wrong join kinds for A ⟕ (B ⋈ C)")
+	}
+	// Every row of A is preserved, although the predicate matches few.
+	if c := s.JoinCardinality(S(x), S(y, z), 100, 10); c != 100 {
+		t.Fatalf("expected A ⟕ (B ⋈ C) to have 100 rows, got %v", c)
+	}
+
+	for _, tc := range []struct {
+		// c is the relation C is joined to above (A ⟕ B).
+		c       RelationID
+		allowed bool
+	}{
+		// (A ⟕ B) ⋈ C = (A ⋈ C) ⟕ B if C is joined to A,
+		{1, true},
+		// but if C is joined to B, B can't be joined to C before A.
+		{2, false},
+	} {
+		b := NewBuilder()
+		x := b.AddRelation("A", 100)
+		y := b.AddRelation("B", 10)
+		z := b.AddRelation("C", 10)
+		b.AddPredicate(x, y, 0.1)
+		b.AddPredicate(tc.c, z, 0.1)
+		b.AddOuterJoin(RightOuterJoin, S(y), S(x))
+		s := b.MustBuild()
+
+		if s.CanJoin(S(tc.c), S(z)) != tc.allowed {
+			t.Errorf("expected joining %s and C to be allowed: %v", s.Relation(tc.c).Name, tc.allowed)
+		}
+		if !s.CanJoin(S(x, y), S(z)) || s.JoinKind(S(x, y), S(z)) != InnerJoin {
+			t.Errorf("expected (A ⟕ B) ⋈ C to be allowed")
+		}
+	}
+
+	// A full outer join can't be reordered with the inner join below it.
+	b = NewBuilder()
+	x = b.AddRelation("A", 100)
+	y = b.AddRelation("B", 10)
+	z = b.AddRelation("C", 10)
+	b.AddPredicate(x, y, 0.1)
+	b.AddPredicate(y, z, 0.1)
+	b.AddOuterJoin(FullOuterJoin, S(x, y), S(z))
+	s = b.MustBuild()
+	if s.CanJoin(S(y), S(z)) || !s.CanJoin(S(z), S(x, y)) || s.JoinKind(S(z), S(x, y)) != FullOuterJoin {
+		t.Fatal("wrong joins allowed for (A ⋈ B) ⟗ C")
+	}
+
+	b = NewBuilder()
+	x = b.AddRelation("A", 100)
+	y = b.AddRelation("B", 10)
+	z = b.AddRelation("C", 10)
+	b.AddPredicate(x, y, 0.1)
+	b.AddPredicate(y, z, 0.1)
+	b.AddOuterJoin(LeftOuterJoin, S(x), S(y))
+	b.AddOuterJoin(LeftOuterJoin, S(x, z), S(y))
+	b.AddOuterJoin(InnerJoin, S(x), S(z))
+	_, err := b.Build()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := "invalid schema: invalid constraint: inner join is not an outer join; " +
+		"invalid constraint: outer joins of (1-3) and (1,2) don't nest"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err)
+	}
+	// Problems found while building are reported once each time.
+	if _, err := b.Build(); err == nil || err.Error() != expected {
+		t.Fatalf("expected %q building again, got %v", expected, err)
+	}
+}
+
+func TestSemiJoins(t *testing.T) {
+	for _, tc := range []struct {
+		kind     JoinKind
+		expected Cardinality
+	}{
+		// Each row of A matches 100 rows of B, but is only produced once.
+		{SemiJoin, 1000},
+		// Every row of A is expected to match, but a few are assumed not to.
+		{AntiJoin, 10},
+	} {
+		b := NewBuilder()
+		x := b.AddRelation("A", 1000)
+		y := b.AddRelation("B", 10000)
+		b.AddPredicate(x, y, 0.01)
+		if tc.kind == SemiJoin {
+			b.AddSemiJoin(S(x), S(y))
+		} else {
+			b.AddAntiJoin(S(x), S(y))
+		}
+		s := b.MustBuild()
+
+		if !s.CanJoin(S(x), S(y)) || s.CanJoin(S(y), S(x)) {
+			t.Errorf("%s: expected B to only be joined on the right", tc.kind)
+		}
+		if s.JoinKind(S(x), S(y)) != tc.kind {
+			t.Errorf("expected a %s, got a %s", tc.kind, s.JoinKind(S(x), S(y)))
+		}
+		if c := s.JoinCardinality(S(x), S(y), 1000, 10000); c != tc.expected {
+			t.Errorf("%s: expected %v rows, got %v", tc.kind, tc.expected, c)
+		}
+	}
+
+	// Half the rows of A are expected to match a row of B.
+	b := NewBuilder()
+	x := b.AddRelation("A", 1000)
+	y := b.AddRelation("B", 10000)
+	b.AddPredicate(x, y, 0.00005)
+	b.AddAntiJoin(S(x), S(y))
+	s := b.MustBuild()
+	if c := s.JoinCardinality(S(x), S(y), 1000, 10000); c != 500 {
+		t.Errorf("expected 500 rows, got %v", c)
+	}
+	if c := s.JoinCardinality(S(x), S(y), 1, 1e9); c != 1 {
+		t.Errorf("expected at least 1 row, got %v", c)
+	}
+
+	// Only 100 values of B can match a row of A.
+	b = NewBuilder()
+	x = b.AddRelation("A", 1000)
+	y = b.AddRelation("B", 10000)
+	z := b.AddRelation("C", 10)
+	b.AddPredicate(x, y, 0.01)
+	b.AddPredicate(x, z, 0.1)
+	b.AddSemiJoin(S(x), S(y))
+	s = b.MustBuild()
+	if c := s.DistinctCardinality(S(y), 10000); c != 100 {
+		t.Errorf("expected 100 distinct rows of B, got %v", c)
+	}
+	if c := s.DistinctCardinality(S(z), 10); c != 10 {
+		t.Errorf("expected 10 distinct rows of C, got %v", c)
+	}
+	// (A ⋉ B) ⋈ C = (A ⋈ C) ⋉ B.
+	if !s.CanJoin(S(x), S(z)) || !s.CanJoin(S(x, z), S(y)) {
+		t.Errorf("expected C to be joined to A before the semi join")
+	}
+
+	b = NewBuilder()
+	x = b.AddRelation("A", 1000)
+	y = b.AddRelation("B", 10000)
+	z = b.AddRelation("C", 10)
+	b.AddPredicate(x, y, 0.01)
+	b.AddPredicate(y, z, 0.1)
+	b.AddSemiJoin(S(x), S(y))
+	_, err := b.Build()
+	expected := "invalid schema: invalid constraint: right side (2) of semi join is joined to (3)"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	b := NewBuilder()
+	x := b.AddRelation("A", 10000)
+	y := b.AddRelation("B", 100)
+	xg := b.AddColumn(x, "g")
+	b.SetColumnStats(xg, ColumnStats{DistinctCount: 10})
+	b.AddPredicate(x, y, 0.01)
+	b.SetGroupBy(xg)
+	s := b.MustBuild()
+
+	// A is grouped on g, and on the 100 values it is joined to B on.
+	for _, tc := range []struct {
+		set      RelSet
+		card     Cardinality
+		expected Cardinality
+	}{
+		{S(x), 10000, 1000},
+		{S(x), 500, 500},
+		{S(y), 100, 100},
+		{S(x, y), 10000, 10},
+	} {
+		if c := s.GroupCardinality(tc.set, tc.card); math.Abs(float64(c-tc.expected)) > 1e-9 {
+			t.Errorf("expected %v groups of %s, got %v", tc.expected, tc.set, c)
+		}
+	}
+
+	b = NewBuilder()
+	x = b.AddRelation("A", 10000)
+	b.SetGroupBy(b.AddColumn(x, "g"))
+	_, err := b.Build()
+	expected := "invalid schema: invalid estimate: GROUP BY column A.g has no statistics"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,217 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+// Shape is the shape of a query graph, whose vertices are the relations and
+// whose edges connect adjacent relations.
+type Shape int
+
+const (
+	// Chain is a path through every relation.
+	Chain Shape = iota
+	// Star is a single relation adjacent to every other relation, none of
+	// which are adjacent to each other.
+	Star
+	// Snowflake is a tree made of a star whose outer relations can have
+	// further relations attached to them, which are leaves.
+	Snowflake
+	// Tree is any other connected graph without cycles.
+	Tree
+	// Cycle is a single cycle through every relation.
+	Cycle
+	// Clique is a graph in which every relation is adjacent to every other.
+	Clique
+	// General is any other graph, including disconnected ones.
+	General
+)
+
+func (s Shape) String() string {
+	switch s {
+	case Chain:
+		return "chain"
+	case Star:
+		return "star"
+	case Snowflake:
+		return "snowflake"
+	case Tree:
+		return "tree"
+	case Cycle:
+		return "cycle"
+	case Clique:
+		return "clique"
+	case General:
+		return "general"
+	}
+	panic(fmt.Sprintf("unknown shape %d", int(s)))
+}
+
+// ShapeReport describes the query graph of a schema.
+type ShapeReport struct {
+	Shape Shape
+	// Components are the connected components of the graph.
+	Components []RelSet
+	Edges      int
+	// Cycles is the number of independent cycles in the graph.
+	Cycles int
+	// Degrees[d] is the number of relations adjacent to d others.
+	Degrees []int
+	// ConnectedSubgraphs is the number of connected sets of relations, and
+	// CsgCmpPairs the number of pairs of them which could be joined to each
+	// other. They bound the work of a dynamic programming orderer.
+	ConnectedSubgraphs int
+	CsgCmpPairs        int
+	// Truncated is set if there were too many connected subgraphs or pairs
+	// to count, in which case the counts are only lower bounds.
+	Truncated bool
+}
+
+// maxEnumeratedCount is how many connected subgraphs, and how many pairs of
+// them, AnalyzeShape counts one at a time before giving up.
+const maxEnumeratedCount = 1 << 20
+
+// AnalyzeShape describes the query graph of s. The connected subgraphs and
+// pairs of them of a chain, star, cycle or clique are counted with a formula,
+// and those of any other graph one at a time, up to maxEnumeratedCount.
+func (s *Schema) AnalyzeShape() ShapeReport {
+	var report ShapeReport
+	var all RelSet
+	all.AddRange(1, s.NumRels())
+
+	report.Components = s.Components()
+
+	maxDegree := 0
+	for i := 1; i <= s.NumRels(); i++ {
+		d := s.Neighbours(RelationID(i)).Len()
+		for len(report.Degrees) <= d {
+			report.Degrees = append(report.Degrees, 0)
+		}
+		report.Degrees[d]++
+		report.Edges += d
+		if d > maxDegree {
+			maxDegree = d
+		}
+	}
+	report.Edges /= 2
+	report.Cycles = report.Edges - s.NumRels() + len(report.Components)
+
+	report.Shape = s.classify(report, maxDegree)
+	report.ConnectedSubgraphs, report.CsgCmpPairs, report.Truncated = s.countSubgraphs(report.Shape, all)
+	return report
+}
+
+// countSubgraphs returns the number of connected subgraphs of the query graph
+// and the number of csg-cmp pairs, and whether they were too many to count.
+// The formulas for the shapes which have one are Moerkotte and Neumann's,
+// with the pairs only counted in one orientation.
+func (s *Schema) countSubgraphs(shape Shape, all RelSet) (csg, ccp int, truncated bool) {
+	n := s.NumRels()
+	switch shape {
+	case Chain:
+		return n * (n + 1) / 2, (n*n*n - n) / 6, false
+	case Cycle:
+		return n*n - n + 1, n * (n - 1) * (n - 1) / 2, false
+	case Star:
+		// 2^(n-1) + n - 1 and (n-1)·2^(n-2).
+		csgs := new(big.Int).Lsh(big.NewInt(1), uint(n-1))
+		csgs.Add(csgs, big.NewInt(int64(n-1)))
+		ccps := new(big.Int).Lsh(big.NewInt(int64(n-1)), uint(n-2))
+		return saturate(csgs, ccps)
+	case Clique:
+		// 2^n - 1 and (3^n - 2^(n+1) + 1) / 2.
+		csgs := new(big.Int).Lsh(big.NewInt(1), uint(n))
+		csgs.Sub(csgs, big.NewInt(1))
+		ccps := new(big.Int).Exp(big.NewInt(3), big.NewInt(int64(n)), nil)
+		ccps.Sub(ccps, new(big.Int).Lsh(big.NewInt(1), uint(n+1)))
+		ccps.Add(ccps, big.NewInt(1))
+		ccps.Rsh(ccps, 1)
+		return saturate(csgs, ccps)
+	}
+
+	complete := s.forEachConnectedSubset(all, func(RelSet) bool {
+		if csg == maxEnumeratedCount {
+			return false
+		}
+		csg++
+		return true
+	})
+	complete = s.forEachCsgCmpPair(all, func(_, _ RelSet) bool {
+		if ccp == maxEnumeratedCount {
+			return false
+		}
+		ccp++
+		return true
+	}) && complete
+	return csg, ccp, !complete
+}
+
+// saturate converts csg and ccp to ints, replacing any which doesn't fit with
+// the largest int and reporting it as truncated.
+func saturate(csgs, ccps *big.Int) (csg, ccp int, truncated bool) {
+	maxInt := big.NewInt(int64(^uint(0) >> 1))
+	if csgs.Cmp(maxInt) > 0 {
+		csgs, truncated = maxInt, true
+	}
+	if ccps.Cmp(maxInt) > 0 {
+		ccps, truncated = maxInt, true
+	}
+	return int(csgs.Int64()), int(ccps.Int64()), truncated
+}
+
+func (s *Schema) classify(report ShapeReport, maxDegree int) Shape {
+	n := s.NumRels()
+	switch {
+	case len(report.Components) > 1:
+		return General
+	case maxDegree <= 2 && report.Cycles == 0:
+		return Chain
+	case maxDegree == 2 && report.Cycles == 1:
+		return Cycle
+	case report.Edges == n*(n-1)/2:
+		return Clique
+	case report.Cycles > 0:
+		return General
+	case maxDegree == n-1:
+		return Star
+	}
+
+	// A snowflake is left with a star, or a single relation or edge, once
+	// its leaves are removed.
+	var inner RelSet
+	for i := 1; i <= n; i++ {
+		if s.Neighbours(RelationID(i)).Len() > 1 {
+			inner.Add(i)
+		}
+	}
+	for i, ok := inner.Next(0); ok; i, ok = inner.Next(i + 1) {
+		if s.Neighbours(RelationID(i)).Intersection(inner).Equals(inner.Difference(S(RelationID(i)))) {
+			return Snowflake
+		}
+	}
+	return Tree
+}
+
+func (r ShapeReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "shape: %s\n", r.Shape)
+	fmt.Fprintf(&buf, "components: %d\n", len(r.Components))
+	fmt.Fprintf(&buf, "edges: %d\n", r.Edges)
+	fmt.Fprintf(&buf, "cycles: %d\n", r.Cycles)
+	buf.WriteString("degrees:")
+	for d, count := range r.Degrees {
+		if count > 0 {
+			fmt.Fprintf(&buf, " %d×%d", count, d)
+		}
+	}
+	buf.WriteByte('\n')
+	atLeast := ""
+	if r.Truncated {
+		atLeast = "≥"
+	}
+	fmt.Fprintf(&buf, "connected subgraphs: %s%d\n", atLeast, r.ConnectedSubgraphs)
+	fmt.Fprintf(&buf, "csg-cmp pairs: %s%d\n", atLeast, r.CsgCmpPairs)
+	return buf.String()
+}
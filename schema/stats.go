@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+)
+
+// ColumnStats are statistics about the values of a column.
+type ColumnStats struct {
+	// DistinctCount is the number of distinct non-null values.
+	DistinctCount float64
+	// NullFraction is the fraction of rows whose value is null.
+	NullFraction float64
+	// Min and Max bound the non-null values of a numeric column. The range
+	// is taken to be unknown if Max <= Min.
+	Min, Max float64
+}
+
+func (c ColumnStats) hasRange() bool {
+	return c.Max > c.Min
+}
+
+// SetColumnStats records statistics about the values of c.
+func (b *Builder) SetColumnStats(c ColumnID, stats ColumnStats) {
+	if !b.validColumn(c) {
+		return
+	}
+	if !(stats.DistinctCount >= 1 && stats.NullFraction >= 0 && stats.NullFraction < 1) {
+		b.problemf(InvalidEstimate, "invalid statistics %+v of column %d", stats, c)
+		return
+	}
+	b.columns[c-1].stats = stats
+	b.columns[c-1].hasStats = true
+}
+
+// AddEstimatedEquality adds a join predicate x = y like AddEquality, but
+// estimates its selectivity from the statistics of the columns rather than
+// taking it explicitly. Both columns must have statistics by the time the
+// schema is built.
+func (b *Builder) AddEstimatedEquality(x, y ColumnID) EqualityID {
+	return b.AddEquality(x, y, 0)
+}
+
+// ColumnStats returns the statistics of c, and false if it has none.
+func (s *Schema) ColumnStats(c ColumnID) (ColumnStats, bool) {
+	col := s.Column(c)
+	return col.stats, col.hasStats
+}
+
+// DistinctCount estimates the number of distinct values of c in the join of
+// set, which must include the relation of c, assuming the join produces card
+// rows. Joining on an equality can only remove values, so a column which is
+// equal to others in set has no more distinct values than the one with the
+// fewest, and no column has more distinct values than there are rows.
+func (s *Schema) DistinctCount(c ColumnID, set RelSet, card Cardinality) float64 {
+	col := s.Column(c)
+	if !set.Contains(int(col.Relation)) {
+		panic(fmt.Sprintf("column %d is not in %s", c, set))
+	}
+	if !col.hasStats {
+		panic(fmt.Sprintf("column %s.%s has no statistics", s.Relation(col.Relation).Name, col.Name))
+	}
+
+	ndv := col.stats.DistinctCount
+	for _, other := range s.EquivalentColumns(c) {
+		o := s.Column(other)
+		if o.hasStats && set.Contains(int(o.Relation)) {
+			ndv = math.Min(ndv, o.stats.DistinctCount)
+		}
+	}
+	return math.Min(ndv, float64(card))
+}
+
+// distinctCountFactor returns the factor by which the selectivity of joining
+// a and b, which produce ca and cb rows, grows once the distinct values of
+// the columns they are joined on are counted with DistinctCount, so that
+// there are no more of them than there are rows on their side. Only the
+// equivalence classes whose equalities between the relations of a and b were
+// all estimated from statistics depend on them.
+func (s *Schema) distinctCountFactor(a, b RelSet, ca, cb Cardinality) float64 {
+	f := 1.0
+	for i := range s.classes {
+		c := &s.classes[i]
+		if !c.rels.Intersects(a) || !c.rels.Intersects(b) || !c.fromStats(s, a.Union(b)) {
+			continue
+		}
+		ra, _ := c.rels.Intersection(a).Next(0)
+		rb, _ := c.rels.Intersection(b).Next(0)
+		x, y := c.cols[RelationID(ra)], c.cols[RelationID(rb)]
+		sx, sy := s.Column(x).stats, s.Column(y).stats
+		sx.DistinctCount = s.DistinctCount(x, a, Cardinality(math.Inf(1)))
+		sy.DistinctCount = s.DistinctCount(y, b, Cardinality(math.Inf(1)))
+		estimated := estimateEquality(sx, sy)
+		sx.DistinctCount = math.Max(s.DistinctCount(x, a, ca), 1)
+		sy.DistinctCount = math.Max(s.DistinctCount(y, b, cb), 1)
+		if estimated > 0 {
+			f *= float64(estimateEquality(sx, sy) / estimated)
+		}
+	}
+	return f
+}
+
+// estimateEquality estimates the selectivity of x = y among the non-null
+// values of x and y as 1/max(ndv), where only the values in the range of both
+// columns can match.
+func estimateEquality(x, y ColumnStats) Selectivity {
+	xFrac, yFrac := 1.0, 1.0
+	if x.hasRange() && y.hasRange() {
+		lo, hi := math.Max(x.Min, y.Min), math.Min(x.Max, y.Max)
+		if lo > hi {
+			return 0
+		}
+		xFrac = math.Max(hi-lo, 0) / (x.Max - x.Min)
+		yFrac = math.Max(hi-lo, 0) / (y.Max - y.Min)
+		if hi == lo {
+			// The ranges only share a single value.
+			xFrac, yFrac = 1/x.DistinctCount, 1/y.DistinctCount
+		}
+	}
+	xDistinct := math.Max(x.DistinctCount*xFrac, 1)
+	yDistinct := math.Max(y.DistinctCount*yFrac, 1)
+	return Selectivity(xFrac * yFrac / math.Max(xDistinct, yDistinct))
+}
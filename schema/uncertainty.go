@@ -0,0 +1,84 @@
+package schema
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Estimates of cardinalities and selectivities can be given an error bound,
+// as a factor q >= 1 by which the true value may differ from the estimate in
+// either direction. A value estimated as v with error q lies somewhere in
+// [v/q, v*q], and is assumed to be distributed log-uniformly over that range.
+
+// SetCardinalityError sets the error bound of the cardinality of r.
+func (b *Builder) SetCardinalityError(r RelationID, q float64) {
+	if !b.validRelation(r) {
+		return
+	}
+	if !(q >= 1) {
+		b.problemf(InvalidEstimate, "invalid error bound %v of %s", q, b.relation(r).Name)
+		return
+	}
+	b.relations[r-1].cardErr = q
+}
+
+// SetSelectivityError sets the error bound of the selectivity of the last
+// predicate added between x and y.
+func (b *Builder) SetSelectivityError(x, y RelationID, q float64) {
+	idx, ok := b.lastPredicate(x, y)
+	if !ok {
+		return
+	}
+	if !(q >= 1) {
+		b.problemf(InvalidEstimate, "invalid error bound %v", q)
+		return
+	}
+	b.predicates[idx].err = q
+}
+
+// CardinalityError returns the error bound of the cardinality of r, which is
+// 1 if it is exact.
+func (s *Schema) CardinalityError(r RelationID) float64 {
+	if q := s.Relation(r).cardErr; q != 0 {
+		return q
+	}
+	return 1
+}
+
+// SelectivityError returns the largest error bound of the selectivities of
+// the predicates between a and b, which is 1 if they are exact.
+func (s *Schema) SelectivityError(a, b RelationID) float64 {
+	q := 1.0
+	for _, i := range s.edges[pair(a, b)] {
+		q = math.Max(q, s.predicates[i].err)
+	}
+	return q
+}
+
+// Sample returns a copy of s in which every cardinality and selectivity with
+// an error bound is replaced with a value drawn from its distribution.
+// Selectivities never exceed 1.
+func (s *Schema) Sample(rng *rand.Rand) *Schema {
+	sample := *s
+	sample.relations = append([]Relation(nil), s.relations...)
+	sample.predicates = append([]Predicate(nil), s.predicates...)
+
+	for i := range sample.relations {
+		r := &sample.relations[i]
+		if r.cardErr > 1 {
+			r.card = Cardinality(float64(r.card) * draw(rng, r.cardErr))
+		}
+	}
+	for i := range sample.predicates {
+		if p := &sample.predicates[i]; p.err > 1 {
+			sel := float64(p.Selectivity) * draw(rng, p.err)
+			p.Selectivity = Selectivity(math.Min(sel, 1))
+		}
+	}
+	return &sample
+}
+
+// draw returns a factor distributed log-uniformly over [1/q, q].
+func draw(rng *rand.Rand, q float64) float64 {
+	return math.Pow(q, 2*rng.Float64()-1)
+}